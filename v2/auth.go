@@ -0,0 +1,362 @@
+package olive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// A Principal is the authenticated identity on whose behalf a request is made.
+type Principal interface {
+	// Subject identifies the principal, e.g. a user name or the id of an API key.
+	Subject() string
+
+	// HasRole reports whether the principal has been granted a role or scope.
+	HasRole(role string) bool
+}
+
+// User is a simple Principal with a name and a set of roles.
+type User struct {
+	Name  string
+	Roles []string
+}
+
+func (u *User) Subject() string { return u.Name }
+
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNoCredentials is returned by an Authenticator when a request carries no
+// credentials for its scheme, allowing the next Authenticator to be tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrInvalidCredentials is returned, possibly wrapped, by an Authenticator or its
+// validation callbacks when a request's credentials are invalid.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// credentialsError describes why a request's credentials are invalid
+type credentialsError string
+
+func (e credentialsError) Error() string { return string(e) }
+
+func (e credentialsError) Is(target error) bool { return target == ErrInvalidCredentials }
+
+// An Authenticator establishes the Principal making a request.
+type Authenticator interface {
+	// Authenticate returns the principal making the request. It returns ErrNoCredentials
+	// if the request has no credentials for the authenticator's scheme, and an error
+	// wrapping ErrInvalidCredentials if the credentials are invalid. An *Error is returned
+	// to the client as is. Any other error is a failure to authenticate the request,
+	// such as an unavailable user store, and fails it with a 500.
+	Authenticate(req *http.Request) (Principal, error)
+
+	// Challenge returns the value of the WWW-Authenticate header sent when authentication
+	// fails, or the empty string if the scheme has no challenge.
+	Challenge() string
+}
+
+// authMiddleware authenticates the request with the first Authenticator that finds
+// credentials and injects the Principal, both as a Principal and as its concrete type.
+// Requests without valid credentials count against the rate limit of their client
+// and fail with a 401, and requests whose principal lacks one of the roles fail with a 403.
+// The body is limited to maxBody for authenticators which read it.
+func authMiddleware(auths []Authenticator, roles []string, rl *RateLimit, maxBody int64) martini.Handler {
+	for _, a := range auths {
+		if a, ok := a.(*JWTAuth); ok {
+			a.validate()
+		}
+	}
+	return func(req *http.Request, w http.ResponseWriter, c martini.Context, e *errEncoder) {
+		if len(auths) == 0 && len(roles) == 0 {
+			return
+		}
+		if maxBody > 0 && req.Body != nil {
+			if req.ContentLength > maxBody {
+				e.Abort(requestTooLarge(maxBody))
+			}
+			req.Body = http.MaxBytesReader(w, req.Body, maxBody)
+		}
+		var (
+			p   Principal
+			err error = ErrNoCredentials
+		)
+		for _, a := range auths {
+			if p, err = a.Authenticate(req); !errors.Is(err, ErrNoCredentials) {
+				break
+			}
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			e.Abort(requestTooLarge(tooLarge.Limit))
+		}
+		if err != nil && !isCredentialsError(err) {
+			e.Abort(err)
+		}
+		if err != nil || p == nil {
+			for _, a := range auths {
+				if ch := a.Challenge(); ch != "" {
+					w.Header().Add("WWW-Authenticate", ch)
+				}
+			}
+			enforceRateLimit(rl, req, w, c, e, nil)
+			e.Abort(unauthorized(err))
+		}
+		for _, role := range roles {
+			if !p.HasRole(role) {
+				e.Abort(&Error{
+					StatusCode: http.StatusForbidden,
+					Message:    "insufficient permissions",
+					Details:    M{"required": roles},
+				})
+			}
+		}
+		c.MapTo(p, (*Principal)(nil))
+		c.Map(p)
+		ctx := context.WithValue(req.Context(), principalKey{}, p)
+		c.Map(req.WithContext(ctx))
+		c.MapTo(ctx, (*context.Context)(nil))
+		if i, ok := p.(injector); ok {
+			i.inject(c)
+		}
+	}
+}
+
+// principalKey is the key of the request's Principal in its context
+type principalKey struct{}
+
+// injector is implemented by olive's principals which inject additional values
+type injector interface {
+	inject(martini.Context)
+}
+
+// isCredentialsError reports whether err is caused by the request's credentials
+// rather than a failure of the authenticator.
+func isCredentialsError(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) || errors.Is(err, ErrNoCredentials) || errors.Is(err, ErrInvalidCredentials)
+}
+
+func unauthorized(err error) *Error {
+	var apiErr *Error
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case err == nil, errors.Is(err, ErrNoCredentials):
+		return &Error{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "authentication required",
+		}
+	case err == ErrInvalidCredentials:
+		return &Error{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "invalid credentials",
+		}
+	default:
+		return &Error{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "invalid credentials",
+			Details:    M{"err": err.Error()},
+		}
+	}
+}
+
+// BasicAuth authenticates requests with HTTP Basic authentication (RFC 7617).
+type BasicAuth struct {
+	Realm string
+
+	// Validate returns the principal for the credentials, or ErrInvalidCredentials if they are invalid.
+	Validate func(user, password string) (Principal, error)
+}
+
+func (a *BasicAuth) Authenticate(req *http.Request) (Principal, error) {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	return a.Validate(user, password)
+}
+
+func (a *BasicAuth) Challenge() string {
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.Realm)
+}
+
+// BearerAuth authenticates requests with a bearer token in the Authorization header (RFC 6750).
+type BearerAuth struct {
+	Realm string
+
+	// Validate returns the principal for the token, or ErrInvalidCredentials if it is invalid.
+	Validate func(token string) (Principal, error)
+}
+
+func (a *BearerAuth) Authenticate(req *http.Request) (Principal, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	return a.Validate(token)
+}
+
+func (a *BearerAuth) Challenge() string {
+	return fmt.Sprintf("Bearer realm=%q", a.Realm)
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token := split(req.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// APIKeyAuth authenticates requests with an API key sent in a header or
+// query string parameter.
+type APIKeyAuth struct {
+	Header string // name of the header holding the key, defaults to X-API-Key
+	Query  string // name of the query parameter holding the key, empty to disallow
+
+	// Validate returns the principal for the key, or ErrInvalidCredentials if it is invalid.
+	Validate func(key string) (Principal, error)
+}
+
+func (a *APIKeyAuth) Authenticate(req *http.Request) (Principal, error) {
+	header := a.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+	key := req.Header.Get(header)
+	if key == "" && a.Query != "" {
+		key = req.URL.Query().Get(a.Query)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	return a.Validate(key)
+}
+
+func (a *APIKeyAuth) Challenge() string {
+	return ""
+}
+
+// HMACAuth authenticates requests signed with a shared secret. Signed requests
+// have an Authorization header of the form
+//
+//	HMAC-SHA256 keyId="<key id>", signature="<base64 signature>"
+//
+// where the signature is the HMAC-SHA256 of the request method, the request URI,
+// the Date header and the hex-encoded SHA-256 of the body, each followed by a
+// newline. See SignRequest.
+type HMACAuth struct {
+	// Key returns the secret and principal of the key id, or ErrInvalidCredentials
+	// if the key id is unknown.
+	Key func(keyID string) (secret []byte, p Principal, err error)
+
+	// maximum difference between the Date header and the current time, defaults to 5 minutes
+	MaxSkew time.Duration
+
+	// returns the current time, defaults to time.Now
+	Now func() time.Time
+}
+
+const hmacScheme = "HMAC-SHA256"
+
+func (a *HMACAuth) Authenticate(req *http.Request) (Principal, error) {
+	scheme, params := split(req.Header.Get("Authorization"), " ")
+	if scheme != hmacScheme {
+		return nil, ErrNoCredentials
+	}
+	var keyID, sig string
+	for _, field := range strings.Split(params, ",") {
+		k, v := split(field, "=")
+		switch k {
+		case "keyId":
+			keyID = strings.Trim(v, `"`)
+		case "signature":
+			sig = strings.Trim(v, `"`)
+		}
+	}
+	if keyID == "" || sig == "" {
+		return nil, credentialsError("malformed HMAC authorization header")
+	}
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return nil, credentialsError("signed requests require a valid Date header")
+	}
+	now, skew := time.Now, a.MaxSkew
+	if a.Now != nil {
+		now = a.Now
+	}
+	if skew == 0 {
+		skew = 5 * time.Minute
+	}
+	if d := now().Sub(date); d > skew || d < -skew {
+		return nil, credentialsError("request date is outside the allowed clock skew")
+	}
+	secret, p, err := a.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := signature(req, secret)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(sig)) != 1 {
+		return nil, credentialsError("invalid request signature")
+	}
+	return p, nil
+}
+
+func (a *HMACAuth) Challenge() string {
+	return hmacScheme
+}
+
+// SignRequest signs a request for authentication by an HMACAuth. The request's
+// Date header is set to the current time if it is missing.
+func SignRequest(req *http.Request, keyID string, secret []byte) error {
+	if req.Header.Get("Date") == "" {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	sig, err := signature(req, secret)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(`%s keyId="%s", signature="%s"`, hmacScheme, keyID, sig))
+	return nil
+}
+
+// signature computes the HMAC signature of the request. The body is read and replaced.
+func signature(req *http.Request, secret []byte) (string, error) {
+	bodyHash := sha256.New()
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return "", err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		bodyHash.Write(body)
+	}
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, req.Method+"\n")
+	io.WriteString(mac, req.URL.RequestURI()+"\n")
+	io.WriteString(mac, req.Header.Get("Date")+"\n")
+	io.WriteString(mac, hex.EncodeToString(bodyHash.Sum(nil))+"\n")
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
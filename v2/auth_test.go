@@ -0,0 +1,83 @@
+package olive_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestAuthCallbackErrorsAreInternal(t *testing.T) {
+	o := olive.Martini()
+	o.Authenticators = []olive.Authenticator{&olive.BearerAuth{
+		Validate: func(token string) (olive.Principal, error) {
+			switch token {
+			case "good":
+				return &olive.User{Name: "alice"}, nil
+			case "bad":
+				return nil, olive.ErrInvalidCredentials
+			}
+			return nil, errors.New("dial tcp 10.0.0.1:5432: connection refused")
+		},
+	}}
+	o.Get("/me", o.Endpoint(func(r olive.Response, u *olive.User) { r.Encode(u.Name) }))
+	c := olivetest.New(t, o)
+
+	c.Get("/me").Header("Authorization", "Bearer good").Send().ExpectStatus(http.StatusOK)
+	c.Get("/me").Header("Authorization", "Bearer bad").Send().ExpectError(http.StatusUnauthorized, 0)
+	resp := c.Get("/me").Header("Authorization", "Bearer down").Send().
+		ExpectError(http.StatusInternalServerError, 0)
+	if d := resp.Error().Details; d != nil {
+		t.Errorf("internal error details sent to the client: %v", d)
+	}
+}
+
+type echoMessage struct {
+	Msg string `json:"msg"`
+}
+
+func TestHMACAuthLimitsBody(t *testing.T) {
+	o := olive.Martini()
+	o.MaxBodySize = 16
+	o.Authenticators = []olive.Authenticator{&olive.HMACAuth{
+		Key: func(keyID string) ([]byte, olive.Principal, error) {
+			if keyID != "k1" {
+				return nil, nil, olive.ErrInvalidCredentials
+			}
+			return []byte("secret"), &olive.User{Name: keyID}, nil
+		},
+	}}
+	o.Post("/echo", o.Endpoint(func(r olive.Response, m *echoMessage) { r.Encode(m) }).Param(echoMessage{}))
+
+	send := func(keyID, body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/echo", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if err := olive.SignRequest(req, keyID, []byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+		if chunked {
+			req.ContentLength = -1
+		}
+		rr := httptest.NewRecorder()
+		o.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send("k1", `{"msg":"hi"}`, false); rr.Code != http.StatusOK {
+		t.Errorf("signed request failed with %d: %s", rr.Code, rr.Body)
+	}
+	if rr := send("k2", `{"msg":"hi"}`, false); rr.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key id got %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	long := `{"msg":"` + strings.Repeat("a", 64) + `"}`
+	if rr := send("k1", long, false); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body got %d, want %d", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if rr := send("k1", long, true); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body of unknown length got %d, want %d", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,251 @@
+package olive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-martini/martini"
+)
+
+// BatchOptions configures a batch endpoint.
+type BatchOptions struct {
+	MaxRequests int // largest number of sub-requests in a batch, defaults to 50
+	Concurrency int // number of sub-requests dispatched concurrently, zero or one dispatches them in order
+}
+
+// A Batch is a list of sub-requests. Its JSON encoding is either a list of
+// sub-requests or an object with a "requests" list.
+type Batch struct {
+	XMLName  xml.Name       `json:"-" xml:"Batch"`
+	Requests []BatchRequest `json:"requests" xml:"Request"`
+}
+
+// A BatchRequest is a sub-request of a Batch. Its body is JSON in a JSON batch
+// and character data in an XML batch.
+type BatchRequest struct {
+	Method  string          `json:"method" xml:"method,attr"`
+	Path    string          `json:"path" xml:"path,attr"`
+	Headers BatchHeaders    `json:"headers,omitempty" xml:"Headers,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty" xml:"Body,omitempty"`
+}
+
+// BatchResults are the responses to the sub-requests of a Batch, in order.
+type BatchResults struct {
+	XMLName   xml.Name        `json:"-" xml:"BatchResults"`
+	Responses []BatchResponse `json:"responses" xml:"Response"`
+}
+
+// A BatchResponse is the response to a sub-request. A JSON body is included
+// as JSON and any other body as a string. If the sub-request failed with an
+// *Error, it is decoded instead of the body.
+type BatchResponse struct {
+	Status  int             `json:"status" xml:"status,attr"`
+	Headers BatchHeaders    `json:"headers,omitempty" xml:"Headers,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty" xml:"Body,omitempty"`
+	Error   *Error          `json:"error,omitempty" xml:"Error,omitempty"`
+}
+
+// BatchHeaders are the headers of a sub-request or sub-response. They are encoded
+// as an object in JSON and as <Header name="...">value</Header> elements in XML.
+type BatchHeaders map[string]string
+
+func (b *Batch) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, &b.Requests)
+	}
+	type batch Batch
+	return json.Unmarshal(data, (*batch)(b))
+}
+
+type batchHeader struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (h BatchHeaders) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hs := make([]batchHeader, len(names))
+	for i, name := range names {
+		hs[i] = batchHeader{name, h[name]}
+	}
+	return e.EncodeElement(struct {
+		Header []batchHeader
+	}{hs}, start)
+}
+
+func (h *BatchHeaders) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var hs struct {
+		Header []batchHeader
+	}
+	if err := d.DecodeElement(&hs, &start); err != nil {
+		return err
+	}
+	*h = make(BatchHeaders, len(hs.Header))
+	for _, hdr := range hs.Header {
+		(*h)[hdr.Name] = hdr.Value
+	}
+	return nil
+}
+
+// batchInherited are the headers of the batch request that its sub-requests inherit
+var batchInherited = []string{"Authorization", "Cookie", "Accept-Language", "Content-Type"}
+
+// Batch registers a POST endpoint at pattern that dispatches a Batch of
+// sub-requests to the Olive's routes in-process and responds with their
+// BatchResults. Sub-requests inherit the batch request's credentials and
+// Content-Type, accept JSON unless they set an Accept header and are run through
+// the routes' full endpoint chains, but not through middleware of the
+// martini.Martini serving the routes.
+//
+//	o.Batch("/batch", olive.BatchOptions{Concurrency: 4})
+func (o *Olive) Batch(pattern string, opts BatchOptions) martini.Route {
+	if opts.MaxRequests == 0 {
+		opts.MaxRequests = 50
+	}
+	dispatcher := martini.New()
+	dispatcher.Action(o.rt.Handle)
+	return o.Post(pattern, o.Endpoint(func(r Response, req *http.Request, batch *Batch) {
+		if req.Context().Value(batchKey{}) != nil {
+			r.Abort(&Error{
+				StatusCode: http.StatusBadRequest,
+				Message:    "batches can't be nested",
+			})
+		}
+		if len(batch.Requests) > opts.MaxRequests {
+			r.Abort(&Error{
+				StatusCode: http.StatusBadRequest,
+				Message:    "too many requests in batch",
+				Details:    M{"max": opts.MaxRequests, "requests": len(batch.Requests)},
+			})
+		}
+		results := &BatchResults{Responses: make([]BatchResponse, len(batch.Requests))}
+		concurrency := opts.Concurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for i := range batch.Requests {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer func() { <-sem; wg.Done() }()
+				defer func() {
+					// a panic outside of the endpoint chains must not crash the process
+					if p := recover(); p != nil {
+						r.Error("batch sub-request panicked", "path", batch.Requests[i].Path, "panic", p)
+						results.Responses[i] = BatchResponse{
+							Status: http.StatusInternalServerError,
+							Error:  &Error{StatusCode: http.StatusInternalServerError, Message: http.StatusText(http.StatusInternalServerError)},
+						}
+					}
+				}()
+				results.Responses[i] = dispatchBatchRequest(dispatcher, req, &batch.Requests[i])
+			}(i)
+		}
+		wg.Wait()
+		r.Encode(results)
+	}).Param(Batch{}).Returns(BatchResults{}))
+}
+
+// batchKey marks the context of a sub-request of a batch, which can't be a batch itself
+type batchKey struct{}
+
+// dispatchBatchRequest serves a sub-request of the batch request
+func dispatchBatchRequest(h http.Handler, parent *http.Request, br *BatchRequest) BatchResponse {
+	invalid := func(msg string) BatchResponse {
+		return BatchResponse{
+			Status: http.StatusBadRequest,
+			Error: &Error{
+				StatusCode: http.StatusBadRequest,
+				Message:    msg,
+				Details:    M{"method": br.Method, "path": br.Path},
+			},
+		}
+	}
+	if !strings.HasPrefix(br.Path, "/") {
+		return invalid("sub-request path must be absolute")
+	}
+	method := strings.ToUpper(br.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	ctx := context.WithValue(parent.Context(), batchKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, method, br.Path, bytes.NewReader(br.Body))
+	if err != nil {
+		return invalid("invalid sub-request")
+	}
+	req.RemoteAddr, req.Host = parent.RemoteAddr, parent.Host
+	for _, name := range batchInherited {
+		if v := parent.Header.Values(name); len(v) > 0 {
+			req.Header[name] = v
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, v := range br.Headers {
+		req.Header.Set(name, v)
+	}
+	if len(br.Body) == 0 {
+		req.Header.Del("Content-Type")
+	}
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	h.ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	resp := BatchResponse{Status: w.status, Headers: make(BatchHeaders, len(w.header))}
+	for name, v := range w.header {
+		resp.Headers[name] = strings.Join(v, ", ")
+	}
+	body := w.body.Bytes()
+	if len(body) == 0 {
+		return resp
+	}
+	if !strings.Contains(w.header.Get("Content-Type"), "json") || !json.Valid(body) {
+		// include the body as a string
+		body, _ = json.Marshal(string(body))
+	} else if w.status >= 400 {
+		var apiErr Error
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.StatusCode != 0 {
+			resp.Error = &apiErr
+			return resp
+		}
+	}
+	resp.Body = bytes.TrimSpace(body)
+	return resp
+}
+
+// batchResponseWriter buffers the response to a sub-request
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
@@ -0,0 +1,54 @@
+package olive_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestBatchRejectsNestedBatches(t *testing.T) {
+	o := olive.Martini()
+	o.Batch("/batch", olive.BatchOptions{})
+	o.Get("/ping", o.Endpoint(func(r olive.Response) { r.Encode("pong") }))
+	c := olivetest.New(t, o)
+
+	for _, path := range []string{"/batch", "/batch/", "/batch?x=1"} {
+		var results olive.BatchResults
+		c.Post("/batch", olive.Batch{Requests: []olive.BatchRequest{
+			{Method: http.MethodGet, Path: "/ping"},
+			{Method: http.MethodPost, Path: path, Body: []byte(`[{"path": "/ping"}]`)},
+		}}).Send().ExpectStatus(http.StatusOK).Decode(&results)
+
+		if got := results.Responses[0].Status; got != http.StatusOK {
+			t.Errorf("%s: ping status %d, want 200", path, got)
+		}
+		nested := results.Responses[1]
+		if nested.Status != http.StatusBadRequest || nested.Error == nil || nested.Error.Message != "batches can't be nested" {
+			t.Errorf("%s: nested batch response %+v, want a 400 error", path, nested)
+		}
+	}
+}
+
+func TestBatchRecoversSubRequestPanics(t *testing.T) {
+	o := olive.Martini()
+	o.Batch("/batch", olive.BatchOptions{Concurrency: 2})
+	// a plain martini handler isn't protected by olive's recovery middleware
+	o.Router.Get("/boom", func() { panic("boom") })
+	o.Get("/ping", o.Endpoint(func(r olive.Response) { r.Encode("pong") }))
+	c := olivetest.New(t, o)
+
+	var results olive.BatchResults
+	c.Post("/batch", olive.Batch{Requests: []olive.BatchRequest{
+		{Path: "/boom"},
+		{Path: "/ping"},
+	}}).Send().ExpectStatus(http.StatusOK).Decode(&results)
+
+	if got := results.Responses[0]; got.Status != http.StatusInternalServerError || got.Error == nil {
+		t.Errorf("panicking sub-request response %+v, want a 500 error", got)
+	}
+	if got := results.Responses[1].Status; got != http.StatusOK {
+		t.Errorf("ping status %d, want 200", got)
+	}
+}
@@ -0,0 +1,451 @@
+package olive
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	log "github.com/inconshreveable/log15/v3"
+)
+
+// A CachePolicy enables server-side caching of an Endpoint's successful GET
+// responses. Responses are cached per path, query string, negotiated content
+// type and language, authenticated principal and the values of the Vary headers.
+// Headers set for each request, like those of CORS and rate limiting, aren't
+// cached, and neither are the responses to principals without a Subject.
+// Concurrent requests for a response that isn't cached wait for the first of
+// them to produce it rather than all running the handler. Stale responses are
+// refreshed by a request served by the OliveMartini, including its martini
+// middleware, or by the Olive's Router alone for an Olive created with New.
+//
+//	accounts := &olive.CachePolicy{TTL: time.Minute, StaleWhileRevalidate: time.Minute}
+//	o.Get("/accounts/:id", o.Endpoint(getAccount).Cache(accounts))
+//	o.Put("/accounts/:id", o.Endpoint(func(r olive.Response, req *http.Request) {
+//		...
+//		accounts.InvalidatePath(req.URL.Path)
+//	}))
+type CachePolicy struct {
+	TTL  time.Duration // how long responses are fresh
+	Vary []string      // request headers whose values select between cached responses
+
+	// whether shared caches may store responses (Cache-Control: public) or only the client (private)
+	Public bool
+
+	// how long after expiring a response may be served while it is refreshed in the background
+	StaleWhileRevalidate time.Duration
+
+	// returns additional tags for the response to a request, for use with InvalidateTag
+	Tags func(req *http.Request) []string
+
+	// where responses are stored, defaults to an in-memory LRU cache of 1024 responses
+	Store CacheStore
+
+	once    sync.Once
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// A CachedResponse is a response stored by a CacheStore.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Tags       []string
+	Stored     time.Time
+}
+
+// A CacheStore stores cached responses.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+
+	// Set stores the response, which may be discarded after ttl.
+	Set(key string, resp *CachedResponse, ttl time.Duration)
+
+	Delete(key string)
+
+	// DeleteTag deletes all responses stored with the tag.
+	DeleteTag(tag string)
+}
+
+// flight tracks the request producing a response for waiting requests
+type flight struct {
+	done chan struct{}
+}
+
+func (p *CachePolicy) init() {
+	p.once.Do(func() {
+		if p.Store == nil {
+			p.Store = NewLRUCache(1024)
+		}
+		p.flights = make(map[string]*flight)
+	})
+}
+
+// Key returns the cache key of the response to the request with the negotiated content type.
+// Responses are cached separately for each authenticated principal, or for each
+// Authorization header if the endpoint doesn't authenticate, and each negotiated language.
+func (p *CachePolicy) Key(req *http.Request, contentType string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s?%s\n%s", req.URL.Path, req.URL.RawQuery, contentType)
+	if loc, ok := req.Context().Value(localeKey{}).(*locale); ok && loc.lang != "" {
+		fmt.Fprintf(&b, "\nlang: %s", loc.lang)
+	}
+	if pr, ok := req.Context().Value(principalKey{}).(Principal); ok {
+		fmt.Fprintf(&b, "\nprincipal: %s", pr.Subject())
+	} else if auth := req.Header.Get("Authorization"); auth != "" {
+		fmt.Fprintf(&b, "\nauthorization: %x", sha256.Sum256([]byte(auth)))
+	}
+	for _, h := range p.Vary {
+		fmt.Fprintf(&b, "\n%s: %s", h, strings.Join(req.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// InvalidateKey removes the response with the key from the cache.
+func (p *CachePolicy) InvalidateKey(key string) {
+	p.init()
+	p.Store.Delete(key)
+}
+
+// InvalidateTag removes all responses with the tag from the cache.
+func (p *CachePolicy) InvalidateTag(tag string) {
+	p.init()
+	p.Store.DeleteTag(tag)
+}
+
+// InvalidatePath removes all cached representations of the resource at path.
+func (p *CachePolicy) InvalidatePath(path string) {
+	p.InvalidateTag(pathTag(path))
+}
+
+func pathTag(path string) string {
+	return "path:" + path
+}
+
+func (p *CachePolicy) cacheControl() string {
+	visibility := "private"
+	if p.Public {
+		visibility = "public"
+	}
+	cc := fmt.Sprintf("%s, max-age=%d", visibility, int(p.TTL/time.Second))
+	if p.StaleWhileRevalidate > 0 {
+		cc += fmt.Sprintf(", stale-while-revalidate=%d", int(p.StaleWhileRevalidate/time.Second))
+	}
+	return cc
+}
+
+// join returns the flight for the key and whether the caller leads it
+func (p *CachePolicy) join(key string) (*flight, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if f, ok := p.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	p.flights[key] = f
+	return f, true
+}
+
+func (p *CachePolicy) land(key string, f *flight) {
+	p.mu.Lock()
+	delete(p.flights, key)
+	p.mu.Unlock()
+	close(f.done)
+}
+
+// cacheMiddleware serves GET and HEAD requests from the cache and stores
+// successful responses produced by the rest of the chain. Stale responses are
+// served while a request dispatched to h refreshes them in the background.
+// If h is nil, requests are dispatched to rt.
+func cacheMiddleware(p *CachePolicy, h http.Handler, rt martini.Router) martini.Handler {
+	if p != nil && h == nil {
+		m := martini.New()
+		m.Action(rt.Handle)
+		h = m
+	}
+	return func(c martini.Context, req *http.Request, w http.ResponseWriter, enc ContentEncoder, l log.Logger) {
+		if p == nil || !safeMethod(req.Method) {
+			return
+		}
+		// responses keyed by an empty subject would be shared between principals
+		if pr, ok := req.Context().Value(principalKey{}).(Principal); ok && pr.Subject() == "" {
+			l.Debug("not caching response to a principal without a subject")
+			return
+		}
+		p.init()
+		rw := w.(martini.ResponseWriter)
+		vary := append([]string{"Accept"}, p.Vary...)
+		rw.Header().Add("Vary", strings.Join(vary, ", "))
+		key := p.Key(req, enc.ContentType)
+
+		// a background revalidation refreshes the response on behalf of its flight
+		if req.Context().Value(revalidationKey{}) == nil {
+			var stale *CachedResponse
+			if cached, ok := p.Store.Get(key); ok {
+				age := time.Since(cached.Stored)
+				if age < p.TTL {
+					serveCached(rw, req, cached, age)
+					return
+				}
+				if age < p.TTL+p.StaleWhileRevalidate {
+					stale = cached
+				}
+			}
+
+			f, leader := p.join(key)
+			switch {
+			case stale != nil:
+				// serve the stale response, and refresh it if no other request is
+				serveCached(rw, req, stale, time.Since(stale.Stored))
+				if leader {
+					go p.revalidate(h, req, key, f, l)
+				}
+				return
+			case !leader:
+				select {
+				case <-f.done:
+					if cached, ok := p.Store.Get(key); ok {
+						serveCached(rw, req, cached, time.Since(cached.Stored))
+						return
+					}
+				case <-req.Context().Done():
+					return
+				}
+				// the response wasn't cacheable, produce our own
+				c.Next()
+				return
+			}
+			defer p.land(key, f)
+		}
+
+		rec := &recorder{ResponseWriter: rw, cacheControl: p.cacheControl()}
+		c.MapTo(rec, (*http.ResponseWriter)(nil))
+		c.Next()
+		if rec.Status() != http.StatusOK || req.Method != http.MethodGet || rec.Header().Get("Set-Cookie") != "" {
+			return
+		}
+		tags := []string{pathTag(req.URL.Path)}
+		if p.Tags != nil {
+			tags = append(tags, p.Tags(req)...)
+		}
+		p.Store.Set(key, &CachedResponse{
+			StatusCode: rec.Status(),
+			Header:     storedHeader(rec.Header()),
+			Body:       rec.body.Bytes(),
+			Tags:       tags,
+			Stored:     time.Now(),
+		}, p.TTL+p.StaleWhileRevalidate)
+		l.Debug("cached response", "ttl", p.TTL)
+	}
+}
+
+// revalidationKey marks the context of a request refreshing a stale response
+type revalidationKey struct{}
+
+// revalidate refreshes the stale response to req by dispatching a copy of it
+// to h, then lands the flight
+func (p *CachePolicy) revalidate(h http.Handler, req *http.Request, key string, f *flight, l log.Logger) {
+	defer p.land(key, f)
+	defer func() {
+		if r := recover(); r != nil {
+			l.Error("failed to revalidate cached response", "err", r)
+		}
+	}()
+	ctx := context.WithValue(detachedContext{req.Context()}, revalidationKey{}, true)
+	sub := req.Clone(ctx)
+	sub.Method = http.MethodGet
+	sub.Header.Del("If-None-Match")
+	sub.Header.Del("If-Modified-Since")
+	h.ServeHTTP(&batchResponseWriter{header: make(http.Header)}, sub)
+}
+
+// detachedContext keeps the values of a request's context but isn't canceled
+// when the request ends
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// requestHeaders are set by the middleware for each request rather than by the
+// handler, so they aren't stored with responses that are replayed to other requests
+var requestHeaders = []string{
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Origin",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+	"Idempotent-Replayed",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"Retry-After",
+	"WWW-Authenticate",
+}
+
+// storedHeader returns a copy of the header of a response without the headers
+// specific to the request it answered
+func storedHeader(h http.Header) http.Header {
+	stored := h.Clone()
+	for _, name := range requestHeaders {
+		stored.Del(name)
+	}
+	return stored
+}
+
+// replayHeader copies a stored header to the response. The Vary header is merged
+// with the one set for the current request.
+func replayHeader(h, stored http.Header) {
+	for k, v := range stored {
+		if k != "Vary" {
+			h[k] = append([]string(nil), v...)
+		}
+	}
+	present := make(map[string]bool)
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			present[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, v := range stored.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" && !present[name] {
+				h.Add("Vary", name)
+				present[name] = true
+			}
+		}
+	}
+}
+
+// serveCached writes a cached response, answering conditional requests with 304
+func serveCached(rw martini.ResponseWriter, req *http.Request, cached *CachedResponse, age time.Duration) {
+	h := rw.Header()
+	replayHeader(h, cached.Header)
+	h.Set("Age", strconv.Itoa(int(age/time.Second)))
+	if checkPreconditions(req, validators{etag: h.Get("ETag")}) == http.StatusNotModified {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.WriteHeader(cached.StatusCode)
+	if req.Method != http.MethodHead {
+		rw.Write(cached.Body)
+	}
+}
+
+// recorder captures the body written to a response and sets the Cache-Control
+// header on successful responses
+type recorder struct {
+	martini.ResponseWriter
+	body         bytes.Buffer
+	cacheControl string
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if status == http.StatusOK && r.cacheControl != "" {
+		r.Header().Set("Cache-Control", r.cacheControl)
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.Written() {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.body.Write(b[:n])
+	return n, err
+}
+
+// LRUCache is an in-memory CacheStore that evicts the least recently used
+// responses when it is full.
+type LRUCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type lruEntry struct {
+	key     string
+	resp    *CachedResponse
+	expires time.Time
+}
+
+// NewLRUCache returns an LRUCache holding up to max responses.
+func NewLRUCache(max int) *LRUCache {
+	return &LRUCache{max: max, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (c *LRUCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.resp, true
+}
+
+func (c *LRUCache) Set(key string, resp *CachedResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&lruEntry{key, resp, time.Now().Add(ttl)})
+	for c.lru.Len() > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *LRUCache) DeleteTag(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		if hasTag(el.Value.(*lruEntry).resp.Tags, tag) {
+			c.remove(el)
+		}
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
@@ -0,0 +1,162 @@
+package olive_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestCacheDoesNotReplayRequestHeaders(t *testing.T) {
+	o := olive.Martini()
+	o.CORS = &olive.CORSPolicy{AllowedOrigins: []string{"https://a.example", "https://b.example"}}
+	o.RateLimit = &olive.RateLimit{Limiter: &olive.TokenBucket{Rate: 10, Per: time.Second}}
+	o.Get("/thing", o.Endpoint(func(r olive.Response) {
+		r.Encode("thing")
+	}).Cache(&olive.CachePolicy{TTL: time.Minute}))
+	c := olivetest.New(t, o)
+
+	c.Get("/thing").Header("Origin", "https://a.example").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "https://a.example").
+		ExpectHeader("RateLimit-Remaining", "9")
+	c.Get("/thing").Header("Origin", "https://b.example").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "https://b.example").
+		ExpectHeader("RateLimit-Remaining", "8").
+		ExpectBody("thing")
+	c.Get("/thing").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "")
+}
+
+func TestCacheKeyedByPrincipal(t *testing.T) {
+	o := olive.Martini()
+	o.Authenticators = []olive.Authenticator{&olive.BasicAuth{
+		Validate: func(user, password string) (olive.Principal, error) {
+			return &olive.User{Name: user}, nil
+		},
+	}}
+	o.Get("/me", o.Endpoint(func(r olive.Response, u *olive.User) {
+		r.Encode(u.Name)
+	}).Cache(&olive.CachePolicy{TTL: time.Minute}))
+	c := olivetest.New(t, o)
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, "secret")
+		c.Get("/me").Header("Authorization", req.Header.Get("Authorization")).Send().
+			ExpectStatus(http.StatusOK).
+			ExpectBody(user)
+	}
+}
+
+func TestCacheKeyedByLanguage(t *testing.T) {
+	o := olive.Martini()
+	o.Messages = olive.Messages{"de": {"hello": "hallo"}, "en": {"hello": "hello"}}
+	o.Get("/greeting", o.Endpoint(func(r olive.Response, req *http.Request) {
+		r.Encode(req.Header.Get("Accept-Language"))
+	}).Cache(&olive.CachePolicy{TTL: time.Minute}))
+	c := olivetest.New(t, o)
+
+	c.Get("/greeting").Header("Accept-Language", "de").Send().ExpectBody("de")
+	c.Get("/greeting").Header("Accept-Language", "en").Send().ExpectBody("en")
+	c.Get("/greeting").Header("Accept-Language", "de-AT").Send().ExpectBody("de")
+}
+
+func TestCacheServesStaleWhileRevalidating(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	o := olive.Martini()
+	o.Get("/slow", o.Endpoint(func(r olive.Response) {
+		n := atomic.AddInt32(&calls, 1)
+		if n > 1 {
+			<-release
+		}
+		r.Encode(n)
+	}).Cache(&olive.CachePolicy{TTL: 10 * time.Millisecond, StaleWhileRevalidate: time.Minute}))
+	c := olivetest.New(t, o)
+
+	c.Get("/slow").Send().ExpectBody(int32(1))
+	time.Sleep(20 * time.Millisecond)
+
+	// the stale response is served while the handler is blocked refreshing it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Get("/slow").Send().ExpectBody(int32(1))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("request for a stale response waited for it to be refreshed")
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		var n int32
+		c.Get("/slow").Send().ExpectStatus(http.StatusOK).Decode(&n)
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale response wasn't refreshed in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("handler called %d times, want 2", n)
+	}
+}
+
+type tenant struct{ name string }
+
+func TestCacheRevalidatesThroughMartiniMiddleware(t *testing.T) {
+	var calls int32
+	o := olive.Martini()
+	o.Use(func(c martini.Context) { c.Map(&tenant{"acme"}) })
+	o.Get("/tenant", o.Endpoint(func(r olive.Response, tn *tenant) {
+		r.Encode(fmt.Sprintf("%s %d", tn.name, atomic.AddInt32(&calls, 1)))
+	}).Cache(&olive.CachePolicy{TTL: 10 * time.Millisecond, StaleWhileRevalidate: time.Minute}))
+	c := olivetest.New(t, o)
+
+	c.Get("/tenant").Send().ExpectBody("acme 1")
+	time.Sleep(20 * time.Millisecond)
+	c.Get("/tenant").Send().ExpectBody("acme 1")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		var body string
+		c.Get("/tenant").Send().ExpectStatus(http.StatusOK).Decode(&body)
+		if body == "acme 2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale response wasn't refreshed through the martini middleware")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCacheSkipsPrincipalsWithoutSubject(t *testing.T) {
+	var calls int32
+	o := olive.Martini()
+	o.Authenticators = []olive.Authenticator{&olive.BearerAuth{
+		Validate: func(token string) (olive.Principal, error) {
+			return &olive.User{}, nil
+		},
+	}}
+	o.Get("/me", o.Endpoint(func(r olive.Response) {
+		r.Encode(atomic.AddInt32(&calls, 1))
+	}).Cache(&olive.CachePolicy{TTL: time.Minute}))
+	c := olivetest.New(t, o)
+
+	c.Get("/me").Header("Authorization", "Bearer a").Send().ExpectBody(int32(1))
+	c.Get("/me").Header("Authorization", "Bearer b").Send().ExpectBody(int32(2))
+}
@@ -0,0 +1,181 @@
+package olive
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-martini/martini"
+)
+
+// An ErrorDef documents an error code that an API can return. ErrorDefs are
+// declared once in a Catalog and used to construct *Errors so that every
+// ErrorCode has a single status code, message and description.
+type ErrorDef struct {
+	ErrorCode   int    `json:"error_code" xml:"ErrorCode"`                        // unique error code
+	StatusCode  int    `json:"status_code" xml:"StatusCode"`                      // http status code
+	Message     string `json:"msg" xml:"Message"`                                 // message template, {name} is replaced by the param called name
+	Description string `json:"description,omitempty" xml:"Description,omitempty"` // documentation for client developers
+	DocURL      string `json:"doc_url,omitempty" xml:"DocURL,omitempty"`          // link to further documentation
+}
+
+// New returns an *Error for the definition. The params are used to fill in the
+// message template and a copy of them is returned to the client as the error's Details.
+//
+//	var errAccountNotFound = catalog.MustRegister(olive.ErrorDef{
+//		ErrorCode:  102,
+//		StatusCode: 404,
+//		Message:    "account {id} not found",
+//	})
+//
+//	r.Abort(errAccountNotFound.New(olive.M{"id": accountId}))
+func (d *ErrorDef) New(params M) *Error {
+	e := &Error{
+		ErrorCode:  d.ErrorCode,
+		StatusCode: d.StatusCode,
+		Message:    formatMessage(d.Message, params),
+		Details:    params,
+		DocURL:     d.DocURL,
+		tmpl:       d.Message,
+	}
+	return e.clone()
+}
+
+// formatMessage replaces each {name} in tmpl with the value of params[name].
+// Placeholders without a param are left as they are.
+func formatMessage(tmpl string, params M) string {
+	if len(params) == 0 || !strings.Contains(tmpl, "{") {
+		return tmpl
+	}
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		v, ok := params[tmpl[start+1:end]]
+		if !ok {
+			// a placeholder may still start after this brace, as in "{{id}"
+			b.WriteString(tmpl[:start+1])
+			tmpl = tmpl[start+1:]
+			continue
+		}
+		b.WriteString(tmpl[:start])
+		fmt.Fprint(&b, v)
+		tmpl = tmpl[end+1:]
+	}
+	b.WriteString(tmpl)
+	return b.String()
+}
+
+// A Catalog is a registry of all of the ErrorDefs an API can return.
+type Catalog struct {
+	mu     sync.RWMutex
+	byCode map[int]*ErrorDef
+}
+
+// NewCatalog returns an empty Catalog.
+func NewCatalog() *Catalog {
+	return &Catalog{byCode: make(map[int]*ErrorDef)}
+}
+
+// Register adds the definition to the catalog. It fails if the definition's
+// ErrorCode is already registered.
+func (c *Catalog) Register(def ErrorDef) (*ErrorDef, error) {
+	if def.StatusCode == 0 {
+		return nil, fmt.Errorf("olive: error code %d has no status code", def.ErrorCode)
+	}
+	if def.Message == "" {
+		def.Message = http.StatusText(def.StatusCode)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.byCode[def.ErrorCode]; ok {
+		return nil, fmt.Errorf("olive: error code %d is already registered (%q)", def.ErrorCode, prev.Message)
+	}
+	d := &def
+	c.byCode[def.ErrorCode] = d
+	return d, nil
+}
+
+// MustRegister is like Register but panics if the definition cannot be added.
+// It is intended to be used when declaring package-level ErrorDefs so that
+// duplicate error codes are detected on startup.
+func (c *Catalog) MustRegister(def ErrorDef) *ErrorDef {
+	d, err := c.Register(def)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Lookup returns the definition registered for the error code.
+func (c *Catalog) Lookup(code int) (*ErrorDef, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	d, ok := c.byCode[code]
+	return d, ok
+}
+
+// Defs returns all registered definitions ordered by error code.
+func (c *Catalog) Defs() []*ErrorDef {
+	c.mu.RLock()
+	defs := make([]*ErrorDef, 0, len(c.byCode))
+	for _, d := range c.byCode {
+		defs = append(defs, d)
+	}
+	c.mu.RUnlock()
+	sort.Slice(defs, func(i, j int) bool { return defs[i].ErrorCode < defs[j].ErrorCode })
+	return defs
+}
+
+type catalogListing struct {
+	XMLName xml.Name    `json:"-" xml:"ErrorCatalog"`
+	Errors  []*ErrorDef `json:"errors" xml:"Error"`
+}
+
+var catalogTemplate = template.Must(template.New("catalog").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Error codes</title></head>
+<body>
+<h1>Error codes</h1>
+<table>
+<tr><th>Error code</th><th>Status</th><th>Message</th><th>Description</th></tr>
+{{range .Errors}}<tr id="{{.ErrorCode}}">
+<td>{{.ErrorCode}}</td><td>{{.StatusCode}}</td><td>{{.Message}}</td>
+<td>{{.Description}}{{if .DocURL}} <a href="{{.DocURL}}">more</a>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var htmlCatalogEncoder = encoderFunc(func(wr io.Writer, v interface{}) error {
+	if l, ok := v.(*catalogListing); ok {
+		return catalogTemplate.Execute(wr, l)
+	}
+	// errors are rendered as text, escaped since they may include request input
+	_, err := io.WriteString(wr, template.HTMLEscapeString(fmt.Sprint(v))+"\n")
+	return err
+})
+
+// ServeCatalog registers a GET endpoint at pattern that documents every error in
+// the catalog. The catalog is served as JSON, XML or HTML depending on the
+// client's Accept header.
+func (o *Olive) ServeCatalog(pattern string, c *Catalog) martini.Route {
+	encs := append([]ContentEncoder{}, o.Encoders...)
+	encs = append(encs, ContentEncoder{"text/html", htmlCatalogEncoder})
+	return o.Get(pattern, o.Endpoint(func(r Response) {
+		r.Encode(&catalogListing{Errors: c.Defs()})
+	}).Encoders(encs))
+}
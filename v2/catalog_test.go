@@ -0,0 +1,140 @@
+package olive_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-martini/martini"
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestCatalogRegister(t *testing.T) {
+	c := olive.NewCatalog()
+	c.MustRegister(olive.ErrorDef{ErrorCode: 2, StatusCode: http.StatusConflict, Message: "conflict"})
+	def := c.MustRegister(olive.ErrorDef{ErrorCode: 1, StatusCode: http.StatusNotFound})
+	if def.Message != "Not Found" {
+		t.Errorf("default message is %q, want %q", def.Message, "Not Found")
+	}
+	if _, err := c.Register(olive.ErrorDef{ErrorCode: 1, StatusCode: http.StatusGone}); err == nil {
+		t.Error("registered a duplicate error code")
+	}
+	if _, err := c.Register(olive.ErrorDef{ErrorCode: 3}); err == nil {
+		t.Error("registered an error code without a status code")
+	}
+	if d, ok := c.Lookup(1); !ok || d != def {
+		t.Errorf("Lookup(1) is %v, %v", d, ok)
+	}
+	if _, ok := c.Lookup(3); ok {
+		t.Error("found an unregistered error code")
+	}
+	if defs := c.Defs(); len(defs) != 2 || defs[0].ErrorCode != 1 || defs[1].ErrorCode != 2 {
+		t.Errorf("Defs() is %v, want codes [1 2]", defs)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("MustRegister didn't panic on a duplicate error code")
+		}
+	}()
+	c.MustRegister(olive.ErrorDef{ErrorCode: 2, StatusCode: http.StatusConflict})
+}
+
+func TestErrorDefNew(t *testing.T) {
+	def := &olive.ErrorDef{
+		ErrorCode:  102,
+		StatusCode: http.StatusNotFound,
+		Message:    "{id_type} {id} not found in {{id}}, {missing}",
+		DocURL:     "https://docs.example.com/102",
+	}
+	params := olive.M{"id": 7, "id_type": "account"}
+	for i := 0; i < 20; i++ {
+		err := def.New(params)
+		if want := "account 7 not found in {7}, {missing}"; err.Message != want {
+			t.Fatalf("message is %q, want %q", err.Message, want)
+		}
+		if err.ErrorCode != 102 || err.StatusCode != http.StatusNotFound || err.DocURL != def.DocURL {
+			t.Fatalf("error is %+v", err)
+		}
+		err.Details["id"] = 8
+	}
+	if params["id"] != 7 {
+		t.Errorf("modifying the error's details changed the params to %v", params)
+	}
+	if err := def.New(nil); err.Message != def.Message || err.Details != nil {
+		t.Errorf("error without params is %+v", err)
+	}
+}
+
+func TestCatalogTranslation(t *testing.T) {
+	def := &olive.ErrorDef{ErrorCode: 102, StatusCode: http.StatusNotFound, Message: "account {id} not found"}
+	o := olive.Martini()
+	o.Messages = olive.Messages{
+		"de": {"account {id} not found": "Konto {id} nicht gefunden"},
+		"fr": {"account {id} not found": "compte {id} introuvable"},
+	}
+	o.Get("/accounts/:id", o.Endpoint(func(r olive.Response, params martini.Params) {
+		r.Abort(def.New(olive.M{"id": params["id"]}))
+	}))
+	c := olivetest.New(t, o)
+
+	for _, tc := range []struct{ header, want string }{
+		{"", "account 7 not found"},
+		{"de", "Konto 7 nicht gefunden"},
+		{"de-AT", "Konto 7 nicht gefunden"},
+		{"es, fr;q=0.5, de;q=0.8", "Konto 7 nicht gefunden"},
+		{"fr, de;q=0", "compte 7 introuvable"},
+		{"es", "account 7 not found"},
+	} {
+		resp := c.Get("/accounts/7").Header("Accept-Language", tc.header).Send().
+			ExpectError(http.StatusNotFound, 102).
+			ExpectErrorDetail("id", "7").
+			ExpectHeader("Vary", "Accept-Language")
+		if msg := resp.Error().Message; msg != tc.want {
+			t.Errorf("Accept-Language %q: message is %q, want %q", tc.header, msg, tc.want)
+		}
+	}
+}
+
+func TestServeCatalog(t *testing.T) {
+	cat := olive.NewCatalog()
+	cat.MustRegister(olive.ErrorDef{
+		ErrorCode:   102,
+		StatusCode:  http.StatusNotFound,
+		Message:     "account {id} not found",
+		Description: "the <account> doesn't exist",
+		DocURL:      "https://docs.example.com/102",
+	})
+	o := olive.Martini()
+	o.Hook(olive.BeforeDecode, func(r olive.Response, req *http.Request) {
+		if q := req.URL.Query().Get("fail"); q != "" {
+			r.Abort(&olive.Error{StatusCode: http.StatusBadRequest, Message: "bad " + q})
+		}
+	})
+	o.ServeCatalog("/errors", cat)
+	c := olivetest.New(t, o)
+
+	var listing struct {
+		Errors []olive.ErrorDef `json:"errors"`
+	}
+	c.Get("/errors").Send().ExpectStatus(http.StatusOK).Decode(&listing)
+	if len(listing.Errors) != 1 || listing.Errors[0].Description != "the <account> doesn't exist" {
+		t.Errorf("JSON listing is %+v", listing)
+	}
+	body := c.Get("/errors").Accept("text/html").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Content-Type", "text/html").
+		Body.String()
+	for _, want := range []string{`<tr id="102">`, "account {id} not found", "the &lt;account&gt; doesn&#39;t exist", `<a href="https://docs.example.com/102">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("HTML listing doesn't contain %q: %s", want, body)
+		}
+	}
+	body = c.Get("/errors").Query("fail", "<script>").Accept("text/html").Send().
+		ExpectStatus(http.StatusBadRequest).
+		ExpectHeader("Content-Type", "text/html").
+		Body.String()
+	if body != "bad &lt;script&gt;\n" {
+		t.Errorf("HTML error is %q", body)
+	}
+}
@@ -0,0 +1,44 @@
+package olive
+
+import (
+	"reflect"
+
+	"github.com/go-martini/martini"
+)
+
+// chainContext runs a list of handlers with the same semantics as martini's
+// route handling. It lets olive build handler chains at request time.
+type chainContext struct {
+	martini.Context
+	handlers []martini.Handler
+	index    int
+}
+
+// runChain invokes hs in the martini context c
+func runChain(c martini.Context, hs []martini.Handler) {
+	cc := &chainContext{Context: c, handlers: hs}
+	c.MapTo(cc, (*martini.Context)(nil))
+	cc.run()
+}
+
+func (c *chainContext) Next() {
+	c.index += 1
+	c.run()
+}
+
+func (c *chainContext) run() {
+	for c.index < len(c.handlers) {
+		vals, err := c.Invoke(c.handlers[c.index])
+		if err != nil {
+			panic(err)
+		}
+		c.index += 1
+		if len(vals) > 0 {
+			ev := c.Get(reflect.TypeOf(martini.ReturnHandler(nil)))
+			ev.Interface().(martini.ReturnHandler)(c, vals)
+		}
+		if c.Written() {
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package olive
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// contextMiddleware derives the request-scoped context.Context, applying the
+// endpoint timeout if there is one. The derived context is injected into the
+// martini context and replaces the context of the injected *http.Request. If the
+// context is done by the time the handlers return and nothing has been written,
+// the request is aborted with an appropriate error.
+//
+// The timeout is cooperative: handlers aren't interrupted, so they must return
+// when the context is done for the timeout to bound the request.
+func contextMiddleware(timeout time.Duration) martini.Handler {
+	return func(c martini.Context, req *http.Request, w http.ResponseWriter, e *errEncoder) {
+		ctx := req.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		c.Map(req.WithContext(ctx))
+		c.MapTo(ctx, (*context.Context)(nil))
+		c.Next()
+		if err := ctx.Err(); err != nil && !w.(martini.ResponseWriter).Written() {
+			e.Abort(err)
+		}
+	}
+}
+
+// contextError translates an error from a done context into an *Error.
+// It returns nil if err is not a context error.
+func contextError(err error) *Error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{
+			StatusCode: http.StatusGatewayTimeout,
+			Message:    "request timed out",
+		}
+	case errors.Is(err, context.Canceled):
+		return &Error{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "request canceled",
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package olive_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15/v3"
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestTimeout(t *testing.T) {
+	o := olive.Martini()
+	o.Timeout = 10 * time.Millisecond
+	o.Get("/slow", o.Endpoint(func(r olive.Response, ctx context.Context) {
+		<-ctx.Done()
+	}))
+	o.Get("/abort", o.Endpoint(func(r olive.Response, ctx context.Context) {
+		<-ctx.Done()
+		r.Abort(ctx.Err())
+	}))
+	o.Get("/fast", o.Endpoint(func(r olive.Response, ctx context.Context, req *http.Request) {
+		_, hasDeadline := ctx.Deadline()
+		r.Encode(hasDeadline && r.Context() == ctx && req.Context() == ctx)
+	}).Timeout(time.Hour))
+	c := olivetest.New(t, o)
+
+	c.Get("/slow").Send().
+		ExpectError(http.StatusGatewayTimeout, 0).
+		ExpectLog(log.LvlWarn, "request timed out")
+	c.Get("/abort").Send().
+		ExpectError(http.StatusGatewayTimeout, 0)
+	c.Get("/fast").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(true)
+}
+
+func TestCanceledRequest(t *testing.T) {
+	o := olive.Martini()
+	o.Get("/wait", o.Endpoint(func(r olive.Response, ctx context.Context) {
+		<-ctx.Done()
+	}))
+	c := olivetest.NewHandler(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancel()
+		o.ServeHTTP(w, req.WithContext(ctx))
+	}))
+
+	resp := c.Get("/wait").Send().ExpectLog(log.LvlInfo, "request canceled")
+	if resp.Body.Len() != 0 {
+		t.Errorf("wrote %q to a canceled request", resp.Body)
+	}
+	for _, r := range resp.Logs() {
+		if r.Lvl <= log.LvlWarn {
+			t.Errorf("canceled request logged at %s: %s", r.Lvl, r.Msg)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package olive
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// A CORSPolicy configures Cross-Origin Resource Sharing for an Endpoint.
+// Preflight requests are answered automatically for every path registered
+// with an Olive, allowing the methods of the routes registered for the path.
+//
+//	o.CORS = &olive.CORSPolicy{
+//		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+//		MaxAge:         time.Hour,
+//	}
+type CORSPolicy struct {
+	// origins allowed to make requests, "*" allows all origins. An entry may contain
+	// wildcards matching a sequence of characters other than '/', e.g. "https://*.example.com"
+	AllowedOrigins []string
+
+	// request headers the client may send, nil allows whichever headers the preflight requests
+	AllowedHeaders []string
+
+	// response headers the client may read in addition to the CORS-safelisted ones
+	ExposedHeaders []string
+
+	// whether the client may send credentials such as cookies or an Authorization header.
+	// Credentials can't be allowed from all origins.
+	AllowCredentials bool
+
+	// how long the results of a preflight request may be cached, zero omits the header
+	MaxAge time.Duration
+}
+
+// clone returns a copy of p that can be modified without affecting p
+func (p *CORSPolicy) clone() *CORSPolicy {
+	c := *p
+	c.AllowedOrigins = append([]string(nil), p.AllowedOrigins...)
+	if p.AllowedHeaders != nil {
+		c.AllowedHeaders = append([]string{}, p.AllowedHeaders...)
+	}
+	c.ExposedHeaders = append([]string(nil), p.ExposedHeaders...)
+	return &c
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	for _, pattern := range p.AllowedOrigins {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsHeader(h string) bool {
+	if p.AllowedHeaders == nil {
+		return true
+	}
+	for _, allowed := range p.AllowedHeaders {
+		if strings.EqualFold(allowed, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// setOriginHeaders sets the headers sent in response to both preflight and actual requests
+func (p *CORSPolicy) setOriginHeaders(h http.Header, origin string) {
+	h.Add("Vary", "Origin")
+	if !p.AllowCredentials && len(p.AllowedOrigins) == 1 && p.AllowedOrigins[0] == "*" {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// validate panics if the policy allows credentials from all origins, which would
+// let any site make authenticated requests on behalf of its visitors
+func (p *CORSPolicy) validate() {
+	if !p.AllowCredentials {
+		return
+	}
+	for _, pattern := range p.AllowedOrigins {
+		if pattern == "*" {
+			panic(`olive: a CORSPolicy allowing credentials can't allow all origins with "*"`)
+		}
+	}
+}
+
+// corsMiddleware applies the policy to cross-origin requests and answers preflight
+// requests. Requests from origins the policy doesn't allow are served without the
+// Access-Control-Allow-* headers, so browsers don't expose the response to the page
+// making the request, while same-origin requests that include an Origin succeed.
+func corsMiddleware(policy *CORSPolicy, routes *routeTable) martini.Handler {
+	if policy != nil {
+		policy.validate()
+	}
+	return func(w http.ResponseWriter, req *http.Request, e *errEncoder, rts martini.Routes) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			return
+		}
+		reqMethod := req.Header.Get("Access-Control-Request-Method")
+		if req.Method == http.MethodOptions && reqMethod != "" {
+			preflight(w, req, reqMethod, policy, routes, rts, e)
+			return
+		}
+		if policy == nil {
+			return
+		}
+		if !policy.allowsOrigin(origin) {
+			w.Header().Add("Vary", "Origin")
+			return
+		}
+		policy.setOriginHeaders(w.Header(), origin)
+		if len(policy.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+	}
+}
+
+// preflight answers a CORS preflight request using the policy of the endpoint
+// registered for the requested method, falling back to the given policy.
+func preflight(w http.ResponseWriter, req *http.Request, reqMethod string, policy *CORSPolicy, routes *routeTable, rts martini.Routes, e *errEncoder) {
+	methods := rts.MethodsFor(req.URL.Path)
+	if len(methods) == 0 {
+		// let the request fall through to a 404
+		return
+	}
+	for _, r := range routes.match(req.URL.Path) {
+		if r.e != nil && (r.method == reqMethod || r.method == "*") {
+			policy = r.e.cors
+			break
+		}
+	}
+	if policy == nil {
+		return
+	}
+
+	h := w.Header()
+	origin := req.Header.Get("Origin")
+	if !policy.allowsOrigin(origin) {
+		h.Add("Vary", "Origin")
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	allowed := allowedMethods(methods)
+	if !methodAllowed(methods, reqMethod) {
+		e.Abort(&Error{
+			StatusCode: http.StatusForbidden,
+			Message:    "cross-origin request method not allowed",
+			Details:    M{"method": reqMethod, "allowed": allowed},
+		})
+	}
+	reqHeaders := make([]string, 0)
+	for _, h := range strings.Split(req.Header.Get("Access-Control-Request-Headers"), ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if !policy.allowsHeader(h) {
+			e.Abort(&Error{
+				StatusCode: http.StatusForbidden,
+				Message:    "cross-origin request header not allowed",
+				Details:    M{"header": h, "allowed": policy.AllowedHeaders},
+			})
+		}
+		reqHeaders = append(reqHeaders, h)
+	}
+
+	policy.setOriginHeaders(h, origin)
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", allowed)
+	if len(reqHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+	}
+	if policy.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge/time.Second)))
+	}
+	h.Del("Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// methodAllowed reports whether a resource with routes for the methods allows the method
+func methodAllowed(methods []string, method string) bool {
+	switch {
+	case method == http.MethodOptions, hasMethod(methods, method), hasMethod(methods, "*"):
+		return true
+	case method == http.MethodHead:
+		return hasMethod(methods, http.MethodGet)
+	}
+	return false
+}
+
+func hasMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
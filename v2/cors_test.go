@@ -0,0 +1,68 @@
+package olive_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestCORSRejectsCredentialsFromAllOrigins(t *testing.T) {
+	o := olive.Martini()
+	o.CORS = &olive.CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	defer func() {
+		if recover() == nil {
+			t.Error("registered an endpoint allowing credentials from all origins")
+		}
+	}()
+	o.Get("/me", o.Endpoint(func(r olive.Response) {}))
+}
+
+func TestCORSCredentials(t *testing.T) {
+	o := olive.Martini()
+	o.CORS = &olive.CORSPolicy{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}
+	o.Get("/me", o.Endpoint(func(r olive.Response) { r.Encode("me") }))
+	c := olivetest.New(t, o)
+
+	c.Get("/me").Header("Origin", "https://app.example.com").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "https://app.example.com").
+		ExpectHeader("Access-Control-Allow-Credentials", "true")
+	c.Get("/me").Header("Origin", "https://evil.example.org").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "").
+		ExpectHeader("Access-Control-Allow-Credentials", "").
+		ExpectHeader("Vary", "Origin")
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	o := olive.Martini()
+	o.CORS = &olive.CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}}
+	o.Post("/notes", o.Endpoint(func(r olive.Response) { r.Encode("created") }))
+	c := olivetest.New(t, o)
+
+	// browsers send an Origin with same-origin POST requests
+	c.Post("/notes", nil).Header("Origin", "http://example.com").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "")
+	c.Do(http.MethodOptions, "/notes").
+		Header("Origin", "https://evil.example.org").
+		Header("Access-Control-Request-Method", http.MethodPost).
+		Send().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Access-Control-Allow-Origin", "").
+		ExpectHeader("Access-Control-Allow-Methods", "")
+	c.Do(http.MethodOptions, "/notes").
+		Header("Origin", "https://app.example.com").
+		Header("Access-Control-Request-Method", http.MethodPost).
+		Send().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Access-Control-Allow-Origin", "https://app.example.com").
+		ExpectHeader("Access-Control-Allow-Methods", "OPTIONS, POST")
+	c.Do(http.MethodOptions, "/notes").
+		Header("Origin", "https://app.example.com").
+		Header("Access-Control-Request-Method", http.MethodDelete).
+		Send().
+		ExpectError(http.StatusForbidden, 0)
+}
@@ -54,8 +54,6 @@ A more advanced example explaining features in detail:
 	package main
 
 	import (
-		"net/http"
-
 		"github.com/go-martini/martini"
 		"github.com/inconshreveable/olive"
 	)
@@ -66,8 +64,8 @@ A more advanced example explaining features in detail:
 		o.Get("/accounts", o.Endpoint(getAccounts).Param(GetAccountsParam{}))
 		o.Get("/accounts/:id", o.Endpoint(getAccount)).Name("accountInstance")
 
-		// serve the API
-		http.ListenAndServe(":8080", o)
+		// serve the API until SIGTERM or SIGINT, then drain in-flight requests
+		o.ListenAndServe(olive.ServerOptions{Addr: ":8080"})
 	}
 
 	// This is the expected request payload for the createAccount endpoint
@@ -90,11 +88,12 @@ A more advanced example explaining features in detail:
 			r.Abort(err)
 		}
 
-		// custom status codes need a call to WriteHeader first
-		r.WriteHeader(201)
-
-		// serialize output
-		r.Encode(ac)
+		// respond 201 Created with the URL of the route named above as the Location
+		loc, err := r.URLFor("accountInstance", ac.ID)
+		if err != nil {
+			r.Abort(err)
+		}
+		r.Created(loc, ac)
 	}
 
 	type GetAccountsParam struct {
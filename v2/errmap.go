@@ -0,0 +1,102 @@
+package olive
+
+import (
+	"errors"
+	"reflect"
+)
+
+// An ErrorMapper translates errors passed to Abort into an *Error. Mappers let
+// application code return domain errors (e.g. sql.ErrNoRows) and have them
+// consistently translated into API errors instead of 500s.
+type ErrorMapper interface {
+	// MapError returns the *Error for err and true, or false if the mapper
+	// does not handle err.
+	MapError(err error) (*Error, bool)
+}
+
+// ErrorMapperFunc adapts a function into an ErrorMapper.
+type ErrorMapperFunc func(error) (*Error, bool)
+
+func (f ErrorMapperFunc) MapError(err error) (*Error, bool) {
+	return f(err)
+}
+
+// MapErrorIs returns an ErrorMapper that translates errors matching target
+// (as reported by errors.Is) into a copy of tmpl.
+//
+//	o.ErrorMappers = append(o.ErrorMappers, olive.MapErrorIs(sql.ErrNoRows, &olive.Error{
+//		StatusCode: 404,
+//		ErrorCode:  100,
+//		Message:    "resource not found",
+//	}))
+func MapErrorIs(target error, tmpl *Error) ErrorMapper {
+	return MapErrorIf(func(err error) bool { return errors.Is(err, target) }, tmpl)
+}
+
+// MapErrorAs returns an ErrorMapper that translates errors whose chain contains
+// an error of the same type as target (as reported by errors.As) into a copy
+// of tmpl. The target is typically a typed nil, e.g. (*os.PathError)(nil).
+func MapErrorAs(target error, tmpl *Error) ErrorMapper {
+	typ := reflect.TypeOf(target)
+	if typ == nil {
+		panic("olive: MapErrorAs target must be a non-nil type")
+	}
+	return MapErrorIf(func(err error) bool {
+		return errors.As(err, reflect.New(typ).Interface())
+	}, tmpl)
+}
+
+// MapErrorIf returns an ErrorMapper that translates errors for which pred
+// returns true into a copy of tmpl. It panics if pred or tmpl is nil.
+func MapErrorIf(pred func(error) bool, tmpl *Error) ErrorMapper {
+	if pred == nil {
+		panic("olive: MapErrorIf predicate must not be nil")
+	}
+	if tmpl == nil {
+		panic("olive: error mapping template must not be nil")
+	}
+	return ErrorMapperFunc(func(err error) (*Error, bool) {
+		if !pred(err) {
+			return nil, false
+		}
+		return tmpl.clone(), true
+	})
+}
+
+// MapError registers a mapping from errors matching target to tmpl in the
+// default ErrorMappers of new Endpoints. See MapErrorIs.
+func (o *Olive) MapError(target error, tmpl *Error) {
+	o.ErrorMappers = append(o.ErrorMappers, MapErrorIs(target, tmpl))
+}
+
+// MapErrorType registers a mapping from errors of target's type to tmpl in
+// the default ErrorMappers of new Endpoints. See MapErrorAs.
+func (o *Olive) MapErrorType(target error, tmpl *Error) {
+	o.ErrorMappers = append(o.ErrorMappers, MapErrorAs(target, tmpl))
+}
+
+// MapErrorFunc registers a mapping from errors matching pred to tmpl in the
+// default ErrorMappers of new Endpoints. See MapErrorIf.
+func (o *Olive) MapErrorFunc(pred func(error) bool, tmpl *Error) {
+	o.ErrorMappers = append(o.ErrorMappers, MapErrorIf(pred, tmpl))
+}
+
+// translateError finds the *Error for err. Wrapped *Errors are unwrapped,
+// then the mappers are consulted in order. It returns false if err could not
+// be translated. The *Error returned is a copy that may be modified, since
+// handlers and mappers commonly return shared package-level values.
+func translateError(err error, mappers []ErrorMapper) (*Error, bool) {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.clone(), true
+	}
+	for _, m := range mappers {
+		if apiErr, ok := m.MapError(err); ok && apiErr != nil {
+			return apiErr.clone(), true
+		}
+	}
+	if apiErr = contextError(err); apiErr != nil {
+		return apiErr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,84 @@
+package olive_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestErrorMapperPrecedence(t *testing.T) {
+	notFound := &olive.Error{StatusCode: http.StatusNotFound, ErrorCode: 1, Details: olive.M{"kind": "missing"}}
+	o := olive.Martini()
+	o.MapError(fs.ErrNotExist, notFound)
+	o.MapErrorType((*fs.PathError)(nil), &olive.Error{StatusCode: http.StatusBadRequest, ErrorCode: 2})
+	o.MapErrorFunc(func(err error) bool { return err.Error() == "busy" }, &olive.Error{StatusCode: http.StatusConflict, ErrorCode: 3})
+	o.Get("/fail", o.Endpoint(func(r olive.Response, req *http.Request) {
+		switch req.URL.Query().Get("err") {
+		case "not-exist":
+			_, err := os.Open("/does/not/exist")
+			r.Abort(fmt.Errorf("opening: %w", err))
+		case "path":
+			r.Abort(&fs.PathError{Op: "open", Path: "x", Err: fs.ErrPermission})
+		case "busy":
+			r.Abort(errors.New("busy"))
+		case "api":
+			r.Abort(fmt.Errorf("wrapped: %w", &olive.Error{StatusCode: http.StatusGone, ErrorCode: 4}))
+		}
+		r.Abort(errors.New("unknown"))
+	}))
+	c := olivetest.New(t, o)
+
+	// the *fs.PathError wrapping fs.ErrNotExist matches the first mapper
+	c.Get("/fail").Query("err", "not-exist").Send().
+		ExpectError(http.StatusNotFound, 1).
+		ExpectErrorDetail("kind", "missing")
+	c.Get("/fail").Query("err", "path").Send().ExpectError(http.StatusBadRequest, 2)
+	c.Get("/fail").Query("err", "busy").Send().ExpectError(http.StatusConflict, 3)
+	c.Get("/fail").Query("err", "api").Send().ExpectError(http.StatusGone, 4)
+	c.Get("/fail").Query("err", "unknown").Send().ExpectError(http.StatusInternalServerError, 0)
+	if notFound.Message != "" {
+		t.Errorf("responding modified the mapping's template: %+v", notFound)
+	}
+}
+
+func TestMappedErrorsAreCopies(t *testing.T) {
+	tmpl := &olive.Error{StatusCode: http.StatusNotFound, Details: olive.M{"kind": "missing"}}
+	m := olive.MapErrorIs(fs.ErrNotExist, tmpl)
+	first, ok := m.MapError(fs.ErrNotExist)
+	if !ok {
+		t.Fatal("error wasn't mapped")
+	}
+	first.Message = "changed"
+	first.Details["kind"] = "changed"
+	second, _ := m.MapError(fs.ErrNotExist)
+	if second == first || second.Message != "" || second.Details["kind"] != "missing" || tmpl.Details["kind"] != "missing" {
+		t.Errorf("mapped errors share state: %+v, %+v, template %+v", first, second, tmpl)
+	}
+	if _, ok := m.MapError(fs.ErrExist); ok {
+		t.Error("mapped a non-matching error")
+	}
+}
+
+func TestMapErrorNilTemplate(t *testing.T) {
+	for name, register := range map[string]func(){
+		"MapErrorIs":   func() { olive.MapErrorIs(fs.ErrNotExist, nil) },
+		"MapErrorAs":   func() { olive.MapErrorAs((*fs.PathError)(nil), nil) },
+		"MapErrorIf":   func() { olive.MapErrorIf(func(error) bool { return true }, nil) },
+		"MapErrorFunc": func() { olive.Martini().MapErrorFunc(nil, &olive.Error{StatusCode: http.StatusNotFound}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s registered a mapping without a template or predicate", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
@@ -1,7 +1,16 @@
 package olive
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
 
 	"github.com/go-martini/martini"
 	log "github.com/inconshreveable/log15/v3"
@@ -14,21 +23,154 @@ type Error struct {
 	ErrorCode  int    `json:"error_code,omitempty" xml:",omitempty"` // unique error code
 	StatusCode int    `json:"status_code"`                           // http status code
 	Message    string `json:"msg"`                                   // user-facing error message
-	Details    M      `json:"details" xml:"-"`                       // extra error context for client, XXX should work in XML
+	Details    M      `json:"details" xml:",omitempty"`              // extra error context for client
+	DocURL     string `json:"doc_url,omitempty" xml:",omitempty"`    // link to documentation of the error code
+
+	tmpl string // message template the error was constructed from, used for translation
 }
 
 func (e *Error) Error() string {
 	return e.Message
 }
 
+// clone returns a copy of e that can be modified without affecting e
+func (e *Error) clone() *Error {
+	c := *e
+	if e.Details != nil {
+		c.Details = make(M, len(e.Details))
+		for k, v := range e.Details {
+			c.Details[k] = v
+		}
+	}
+	return &c
+}
+
 // a Map of extra error details
 type M map[string]interface{}
 
+// MarshalXML encodes the map as an element with a child element for each key, in sorted order.
+// Nested maps are encoded the same way. Keys that aren't valid XML names are encoded as a
+// <detail key="..."> element, and values that can't be encoded as XML are left out.
+func (m M) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := m[k]
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Map && rv.Type() != reflect.TypeOf(m) {
+			nested := make(M, rv.Len())
+			for _, mk := range rv.MapKeys() {
+				nested[fmt.Sprint(mk.Interface())] = rv.MapIndex(mk).Interface()
+			}
+			v = nested
+		}
+		el := xml.StartElement{Name: xml.Name{Local: k}}
+		if !isXMLName(k) {
+			el = xml.StartElement{
+				Name: xml.Name{Local: "detail"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: k}},
+			}
+		}
+		// encode the value on its own first so that a failure doesn't leave a partial element
+		if err := xml.NewEncoder(io.Discard).EncodeElement(v, el); err != nil {
+			continue
+		}
+		if err := e.EncodeElement(v, el); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// isXMLName reports whether s can be used as the name of an XML element
+func isXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r), r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// UnmarshalXML decodes the child elements of an element encoded by MarshalXML.
+// Elements with children are decoded as nested maps and others as strings. Repeated
+// elements are decoded as a slice, and <detail key="..."> elements under their key.
+func (m *M) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	v, err := decodeXMLElement(d)
+	if err != nil {
+		return err
+	}
+	if nested, ok := v.(M); ok {
+		*m = nested
+	} else {
+		*m = M{}
+	}
+	return nil
+}
+
+// decodeXMLElement decodes the content of the element whose start was just read
+func decodeXMLElement(d *xml.Decoder) (interface{}, error) {
+	var (
+		text     strings.Builder
+		children M
+	)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			v, err := decodeXMLElement(d)
+			if err != nil {
+				return nil, err
+			}
+			if children == nil {
+				children = M{}
+			}
+			k := tok.Name.Local
+			if k == "detail" {
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "key" {
+						k = attr.Value
+					}
+				}
+			}
+			switch prev := children[k].(type) {
+			case nil:
+				children[k] = v
+			case []interface{}:
+				children[k] = append(prev, v)
+			default:
+				children[k] = []interface{}{prev, v}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			if children != nil {
+				return children, nil
+			}
+			return text.String(), nil
+		}
+	}
+}
+
 // errEncoderMiddleware injects an ErrEncoder into the martini context
 // ErrEncoderMiddleware is automatically included in the middleware chain for
 // all olive API endpoints.
-func errEncoderMiddleware(debug bool) martini.Handler {
-	return func(c martini.Context, w http.ResponseWriter, enc Encoder, l log.Logger) {
+func errEncoderMiddleware(debug bool, mappers []ErrorMapper) martini.Handler {
+	return func(c martini.Context, w http.ResponseWriter, enc Encoder, l log.Logger, loc *locale) {
 		defer func() {
 			if p := recover(); p != nil {
 				if _, ok := p.(abort); ok {
@@ -37,7 +179,7 @@ func errEncoderMiddleware(debug bool) martini.Handler {
 				panic(p)
 			}
 		}()
-		c.Map(&errEncoder{enc: enc, l: l, w: w.(martini.ResponseWriter), debug: debug})
+		c.Map(&errEncoder{enc: enc, l: l, w: w.(martini.ResponseWriter), debug: debug, mappers: mappers, loc: loc})
 		c.Next()
 	}
 }
@@ -46,14 +188,19 @@ func errEncoderMiddleware(debug bool) martini.Handler {
 type abort struct{}
 
 type errEncoder struct {
-	enc   Encoder
-	l     log.Logger
-	w     martini.ResponseWriter
-	debug bool
+	enc     Encoder
+	l       log.Logger
+	w       martini.ResponseWriter
+	debug   bool
+	mappers []ErrorMapper
+	loc     *locale
+
+	// set once the connection is upgraded to a WebSocket, errors are then sent as messages
+	ws *WebSocket
 }
 
 func (e *errEncoder) abort(err error) {
-	apiErr, ok := err.(*Error)
+	apiErr, ok := translateError(err, e.mappers)
 	if !ok {
 		apiErr = internalServerError(err)
 	}
@@ -67,7 +214,13 @@ func (e *errEncoder) abort(err error) {
 		apiErr.Message = http.StatusText(apiErr.StatusCode)
 	}
 
-	// log the error
+	// cancellations are expected and aren't failures of the handler. Nothing is
+	// written since the client has gone away.
+	if errors.Is(err, context.Canceled) {
+		e.l.Info(apiErr.Message, logDetails)
+		return
+	}
+
 	logFn := e.l.Warn
 	if apiErr.StatusCode == http.StatusInternalServerError {
 		logFn = e.l.Error
@@ -77,11 +230,33 @@ func (e *errEncoder) abort(err error) {
 	}
 	logFn(apiErr.Message, logDetails)
 
-	// write to response only if nothing else has been written
-	if !e.w.Written() {
-		e.w.WriteHeader(apiErr.StatusCode)
-		e.enc.Encode(e.w, apiErr)
+	e.write(apiErr)
+}
+
+// write encodes the error to the response, translating its message to the
+// negotiated language. Nothing is written if the response has already been started.
+// After a WebSocket upgrade, the error is sent as a message on the connection.
+func (e *errEncoder) write(apiErr *Error) {
+	if e.ws == nil && e.w.Written() {
+		return
+	}
+	key := apiErr.tmpl
+	if key == "" {
+		key = apiErr.Message
+	}
+	translated, ok := e.loc.translate(key)
+	if ok {
+		apiErr.Message = formatMessage(translated, apiErr.Details)
+	}
+	if e.ws != nil {
+		e.ws.Send(apiErr)
+		return
+	}
+	if ok {
+		e.w.Header().Set("Content-Language", e.loc.lang)
 	}
+	e.w.WriteHeader(apiErr.StatusCode)
+	e.enc.Encode(e.w, apiErr)
 }
 
 func (e *errEncoder) Abort(err error) {
@@ -0,0 +1,82 @@
+package olive_test
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+var errGone = &olive.Error{StatusCode: http.StatusGone, ErrorCode: 7}
+
+var errMissing = errors.New("missing")
+
+func TestErrorTranslationDoesNotModifySharedErrors(t *testing.T) {
+	o := olive.Martini()
+	o.Messages = olive.Messages{"de": {"Gone": "Weg", "not here": "nicht hier"}}
+	o.MapError(errMissing, &olive.Error{StatusCode: http.StatusNotFound, Message: "not here"})
+	o.Get("/gone", o.Endpoint(func(r olive.Response) { r.Abort(errGone) }))
+	o.Get("/missing", o.Endpoint(func(r olive.Response) { r.Abort(errMissing) }))
+	c := olivetest.New(t, o)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(lang string) {
+			defer wg.Done()
+			c.Get("/gone").Header("Accept-Language", lang).Send().ExpectError(http.StatusGone, 7)
+			c.Get("/missing").Header("Accept-Language", lang).Send().ExpectError(http.StatusNotFound, 0)
+		}([]string{"de", "en"}[i%2])
+	}
+	wg.Wait()
+
+	if errGone.Message != "" {
+		t.Errorf("shared error's message was set to %q", errGone.Message)
+	}
+	if msg := c.Get("/gone").Send().Error().Message; msg != "Gone" {
+		t.Errorf("untranslated message is %q, want %q", msg, "Gone")
+	}
+	if msg := c.Get("/gone").Header("Accept-Language", "de").Send().Error().Message; msg != "Weg" {
+		t.Errorf("translated message is %q, want %q", msg, "Weg")
+	}
+	if msg := c.Get("/missing").Send().Error().Message; msg != "not here" {
+		t.Errorf("untranslated mapped message is %q, want %q", msg, "not here")
+	}
+}
+
+func TestXMLErrorDetails(t *testing.T) {
+	o := olive.Martini()
+	o.Get("/fail", o.Endpoint(func(r olive.Response) {
+		r.Abort(&olive.Error{
+			StatusCode: http.StatusBadRequest,
+			Message:    "bad request",
+			Details: olive.M{
+				"field name": "email",
+				"2fa":        true,
+				"xmlns":      "x",
+				"ok":         "yes",
+				"callback":   func() {},
+				"nested":     map[string]interface{}{"a/b": 1, "ch": make(chan int)},
+			},
+		})
+	}))
+	c := olivetest.New(t, o)
+
+	resp := c.Get("/fail").Accept("application/xml").Send().
+		ExpectError(http.StatusBadRequest, 0).
+		ExpectHeader("Content-Type", "application/xml")
+	want := olive.M{
+		"field name": "email",
+		"2fa":        "true",
+		"xmlns":      "x",
+		"ok":         "yes",
+		"nested":     olive.M{"a/b": "1"},
+	}
+	if got := resp.Error().Details; !reflect.DeepEqual(got, want) {
+		t.Errorf("details are %#v, want %#v; body: %s", got, want, resp.Body)
+	}
+}
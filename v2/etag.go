@@ -0,0 +1,198 @@
+package olive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// ETagMode determines whether an Endpoint generates ETags for the
+// representations it encodes.
+type ETagMode int
+
+const (
+	// NoETags disables ETag generation. Handlers may still set ETags explicitly.
+	NoETags ETagMode = iota
+
+	// StrongETags generates strong ETags, for representations that are byte-for-byte identical.
+	StrongETags
+
+	// WeakETags generates weak ETags, for representations that are semantically equivalent.
+	WeakETags
+)
+
+// ErrNotModified is returned by Encode when it answers a conditional GET of
+// an unchanged representation with 304 Not Modified instead of writing it.
+var ErrNotModified = errors.New("not modified")
+
+// validators are the ETag and Last-Modified time of the response's resource
+type validators struct {
+	etag         string // including quotes and the weak prefix
+	lastModified time.Time
+}
+
+// representationETag returns an ETag for the encoded representation. The
+// content type is included so that each representation has a distinct ETag.
+func representationETag(contentType string, body []byte, weak bool) string {
+	h := sha256.New()
+	h.Write([]byte(contentType))
+	h.Write([]byte{0})
+	h.Write(body)
+	return formatETag(hex.EncodeToString(h.Sum(nil)[:16]), weak)
+}
+
+func formatETag(tag string, weak bool) string {
+	tag = `"` + strings.Trim(tag, `"`) + `"`
+	if weak {
+		tag = "W/" + tag
+	}
+	return tag
+}
+
+// etagMatch reports whether the ETag matches the value of an If-Match or
+// If-None-Match header. Weak comparison ignores the weak prefix.
+func etagMatch(header, etag string, weakCompare bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weakCompare {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// checkPreconditions evaluates the request's conditional headers against the
+// validators as described by RFC 7232 section 6. It returns the status code the
+// request should be answered with, or 0 if the request should proceed.
+func checkPreconditions(req *http.Request, v validators) int {
+	if im := req.Header.Get("If-Match"); im != "" {
+		// "*" matches any current representation, other ETags can't match a
+		// resource without one (RFC 9110 section 13.1.1)
+		if strings.TrimSpace(im) != "*" && !etagMatch(im, v.etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := req.Header.Get("If-Unmodified-Since"); ius != "" && !v.lastModified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && v.lastModified.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, v.etag, true) {
+			if safeMethod(req.Method) {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && safeMethod(req.Method) && !v.lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !v.lastModified.Truncate(time.Second).After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// SetETag sets the ETag of the response's resource and evaluates the request's
+// conditional headers. Conditional GETs of an unchanged resource are answered
+// with 304 Not Modified, and unsafe requests whose preconditions fail are aborted
+// with 412 Precondition Failed. In either case the handler does not continue.
+//
+//	func updateAccount(r olive.Response, p martini.Params, in *AccountUpdate) {
+//		ac := account.GetById(p["id"])
+//		r.SetETag(ac.Version, false) // aborts if the client's If-Match is stale
+//		...
+//	}
+func (r *response) SetETag(etag string, weak bool) {
+	r.v.etag = formatETag(etag, weak)
+	r.Header().Set("ETag", r.v.etag)
+	r.evaluatePreconditions()
+}
+
+// SetETagFor sets an ETag computed from v encoded with the negotiated encoder,
+// the same ETag the endpoint generates when encoding v. See SetETag.
+func (r *response) SetETagFor(v interface{}) {
+	var buf bytes.Buffer
+	if err := r.enc.Encode(&buf, v); err != nil {
+		r.Abort(err)
+	}
+	r.v.etag = representationETag(r.enc.ContentType, buf.Bytes(), r.etags == WeakETags)
+	r.Header().Set("ETag", r.v.etag)
+	r.evaluatePreconditions()
+}
+
+// SetLastModified sets the modification time of the response's resource and
+// evaluates the request's conditional headers. See SetETag. Resources with both
+// validators must set their ETag first, as requests with an If-Match header fail
+// against resources without one.
+func (r *response) SetLastModified(t time.Time) {
+	r.v.lastModified = t
+	r.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	r.evaluatePreconditions()
+}
+
+func (r *response) evaluatePreconditions() {
+	switch checkPreconditions(r.req, r.v) {
+	case http.StatusNotModified:
+		r.notModified()
+	case http.StatusPreconditionFailed:
+		r.Abort(&Error{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    "precondition failed",
+			Details:    M{"etag": r.v.etag},
+		})
+	}
+}
+
+// notModified answers the request with 304 Not Modified and stops the handler
+func (r *response) notModified() {
+	r.writeNotModified()
+	panic(abort{})
+}
+
+func (r *response) writeNotModified() {
+	if !r.Written() {
+		h := r.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		r.WriteHeader(http.StatusNotModified)
+	}
+}
+
+func unsafeMethod(method string) bool {
+	return method == http.MethodPut || method == http.MethodPatch || method == http.MethodDelete
+}
+
+// preconditionMiddleware requires unsafe requests to be conditional
+func preconditionMiddleware(required bool) martini.Handler {
+	return func(req *http.Request, e *errEncoder) {
+		if !required || !unsafeMethod(req.Method) {
+			return
+		}
+		if req.Header.Get("If-Match") == "" && req.Header.Get("If-Unmodified-Since") == "" {
+			e.Abort(&Error{
+				StatusCode: http.StatusPreconditionRequired,
+				Message:    "request must be conditional",
+				Details:    M{"headers": []string{"If-Match", "If-Unmodified-Since"}},
+			})
+		}
+	}
+}
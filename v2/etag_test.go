@@ -0,0 +1,47 @@
+package olive_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestIfMatchWithoutETag(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	o := olive.Martini()
+	o.Put("/doc", o.Endpoint(func(r olive.Response) {
+		r.SetLastModified(modified)
+		r.Encode("updated")
+	}))
+	c := olivetest.New(t, o)
+
+	c.Put("/doc", "").Header("If-Match", `"v1"`).Send().
+		ExpectError(http.StatusPreconditionFailed, 0)
+	c.Put("/doc", "").Header("If-Match", "*").Send().
+		ExpectStatus(http.StatusOK)
+	c.Put("/doc", "").Header("If-Unmodified-Since", modified.Format(http.TimeFormat)).Send().
+		ExpectStatus(http.StatusOK)
+}
+
+func TestEncodeReturnsErrNotModified(t *testing.T) {
+	var encodeErr error
+	o := olive.Martini()
+	o.Get("/doc", o.Endpoint(func(r olive.Response) {
+		encodeErr = r.Encode("unchanged")
+	}).ETags(olive.StrongETags))
+	c := olivetest.New(t, o)
+
+	etag := c.Get("/doc").Send().ExpectStatus(http.StatusOK).Header().Get("ETag")
+	if encodeErr != nil {
+		t.Fatalf("unconditional Encode returned %v", encodeErr)
+	}
+	c.Get("/doc").Header("If-None-Match", etag).Send().
+		ExpectStatus(http.StatusNotModified)
+	if !errors.Is(encodeErr, olive.ErrNotModified) {
+		t.Errorf("conditional Encode returned %v, want ErrNotModified", encodeErr)
+	}
+}
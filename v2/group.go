@@ -0,0 +1,51 @@
+package olive
+
+import (
+	"github.com/go-martini/martini"
+)
+
+// Group returns a child Olive whose routes are registered under prefix.
+// The child inherits a copy of the parent's Endpoint defaults (encoders,
+// decoders, debug flag, timeout, body size limit, error mappers, CORS policy,
+// hooks and so on) as they are when Group is called; customizing the child
+// changes the defaults of the Endpoints it creates without affecting the parent.
+// The child shares the parent's health checks and the table of routes used to
+// answer OPTIONS requests.
+//
+// The handlers hs are run by every Endpoint the child creates, after olive's
+// authentication and before the request parameter is decoded, so they may be
+// injected with an olive.Response and reject requests before their body is read.
+// Groups nest arbitrarily: prefixes are composed and middleware accumulates.
+//
+//	admin := o.Group("/admin", requireAdmin)
+//	admin.Debug = true
+//	admin.Get("/users", admin.Endpoint(listUsers))
+func (o *Olive) Group(prefix string, hs ...martini.Handler) *Olive {
+	g := *o
+	g.prefix = o.prefix + prefix
+	g.middleware = append(append([]martini.Handler{}, o.middleware...), hs...)
+	g.Encoders = append([]ContentEncoder{}, o.Encoders...)
+	g.Decoders = make(map[string]Decoder, len(o.Decoders))
+	for ct, dec := range o.Decoders {
+		g.Decoders[ct] = dec
+	}
+	g.ErrorMappers = append([]ErrorMapper{}, o.ErrorMappers...)
+	g.CrashReporters = append([]CrashReporter{}, o.CrashReporters...)
+	g.Authenticators = append([]Authenticator{}, o.Authenticators...)
+	g.RequiredRoles = append([]string{}, o.RequiredRoles...)
+	g.TrustedProxies = append([]string{}, o.TrustedProxies...)
+	if o.CORS != nil {
+		g.CORS = o.CORS.clone()
+	}
+	if o.RateLimit != nil {
+		limit := *o.RateLimit
+		g.RateLimit = &limit
+	}
+	g.hooks = o.hooks.copy()
+	return &g
+}
+
+// Prefix returns the path prefix under which the Olive registers routes.
+func (o *Olive) Prefix() string {
+	return o.prefix
+}
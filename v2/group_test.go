@@ -0,0 +1,76 @@
+package olive_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestGroup(t *testing.T) {
+	var order []string
+	o := olive.Martini()
+	admin := o.Group("/admin", func() { order = append(order, "admin") })
+	v1 := admin.Group("/v1", func() { order = append(order, "v1") })
+	v1.Get("/users", v1.Endpoint(func(r olive.Response) {
+		order = append(order, "handler")
+		r.Encode("users")
+	}))
+	c := olivetest.New(t, o)
+
+	c.Get("/admin/v1/users").Send().ExpectStatus(http.StatusOK)
+	if len(order) != 3 || order[0] != "admin" || order[1] != "v1" || order[2] != "handler" {
+		t.Errorf("ran %v, want [admin v1 handler]", order)
+	}
+	c.Delete("/admin/v1/users").Send().
+		ExpectError(http.StatusMethodNotAllowed, 0).
+		ExpectHeader("Allow", "GET, HEAD, OPTIONS")
+	if prefix := v1.Prefix(); prefix != "/admin/v1" {
+		t.Errorf("prefix is %q, want /admin/v1", prefix)
+	}
+}
+
+func TestGroupDoesNotModifyParent(t *testing.T) {
+	o := olive.Martini()
+	o.CORS = &olive.CORSPolicy{AllowedOrigins: []string{"https://a.example.com"}}
+	g := o.Group("/g")
+	g.CORS.AllowedOrigins[0] = "https://b.example.com"
+	delete(g.Decoders, "application/xml")
+	g.Decoders["text/plain"] = o.Decoders["application/json"]
+	o.Post("/echo", o.Endpoint(func(r olive.Response, m *echoMessage) { r.Encode(m) }).Param(echoMessage{}))
+	g.Post("/echo", g.Endpoint(func(r olive.Response, m *echoMessage) { r.Encode(m) }).Param(echoMessage{}))
+	c := olivetest.New(t, o)
+
+	c.Post("/echo", `<echoMessage><Msg>hi</Msg></echoMessage>`).ContentType("application/xml").
+		Header("Origin", "https://a.example.com").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "https://a.example.com")
+	c.Post("/echo", `{"msg":"hi"}`).ContentType("text/plain").Send().
+		ExpectError(http.StatusUnsupportedMediaType, 0)
+	c.Post("/g/echo", `{"msg":"hi"}`).ContentType("text/plain").
+		Header("Origin", "https://b.example.com").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "https://b.example.com")
+	c.Post("/g/echo", `<echoMessage><Msg>hi</Msg></echoMessage>`).ContentType("application/xml").Send().
+		ExpectError(http.StatusUnsupportedMediaType, 0)
+}
+
+func TestGroupMiddlewareRunsBeforeDecoding(t *testing.T) {
+	o := olive.Martini()
+	g := o.Group("/private", func(r olive.Response, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			r.Abort(&olive.Error{StatusCode: http.StatusUnauthorized})
+		}
+	})
+	g.Post("/echo", g.Endpoint(func(r olive.Response, m *echoMessage) { r.Encode(m) }).Param(echoMessage{}))
+	c := olivetest.New(t, o)
+
+	c.Post("/private/echo", `{"msg":`).Send().
+		ExpectError(http.StatusUnauthorized, 0)
+	c.Post("/private/echo", `{"msg":`).Header("Authorization", "yes").Send().
+		ExpectError(http.StatusBadRequest, 0)
+	c.Post("/private/echo", echoMessage{Msg: "hi"}).Header("Authorization", "yes").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(map[string]interface{}{"msg": "hi"})
+}
@@ -0,0 +1,240 @@
+package olive
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health check statuses
+const (
+	HealthPass = "pass"
+	HealthWarn = "warn" // a non-critical check is failing
+	HealthFail = "fail"
+)
+
+// A HealthCheck is a named check of a component the service depends on.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+
+	Timeout  time.Duration // how long the check may take, defaults to 5 seconds
+	Critical bool          // whether the service is unhealthy if the check fails
+	Liveness bool          // whether the check is also run for the liveness endpoint
+	CacheFor time.Duration // how long a result is reused, zero runs the check for every request
+}
+
+// A HealthResult is the result of a HealthCheck.
+type HealthResult struct {
+	Name     string    `json:"name" xml:"name,attr"`
+	Status   string    `json:"status" xml:"status,attr"`
+	Critical bool      `json:"critical" xml:"critical,attr"`
+	Error    string    `json:"error,omitempty" xml:"Error,omitempty"`
+	Duration float64   `json:"duration_ms" xml:"DurationMs"`
+	Checked  time.Time `json:"checked" xml:"Checked"`
+}
+
+// A HealthReport is the response of a health endpoint.
+type HealthReport struct {
+	XMLName xml.Name       `json:"-" xml:"Health"`
+	Status  string         `json:"status" xml:"Status"`
+	Ready   bool           `json:"ready" xml:"Ready"`
+	Checks  []HealthResult `json:"checks" xml:"Check"`
+}
+
+// HealthPaths are the paths health endpoints are served at. Empty paths use the defaults.
+type HealthPaths struct {
+	Health    string // all checks, defaults to /healthz
+	Liveness  string // checks marked Liveness, defaults to /livez
+	Readiness string // all checks and the readiness toggle, defaults to /readyz
+}
+
+// Health is the registry of an Olive's health checks.
+type Health struct {
+	mu       sync.RWMutex
+	checks   []*healthCheck
+	notReady bool
+}
+
+type healthCheck struct {
+	HealthCheck
+	mu      sync.Mutex
+	last    HealthResult
+	expires time.Time
+	flight  *healthFlight // the run of the check in progress, if any
+}
+
+// healthFlight is a run of a check whose result is shared by concurrent probes
+type healthFlight struct {
+	done chan struct{} // closed once res is set
+	res  HealthResult
+}
+
+// Register adds a check. It panics if a check with the same name is registered.
+func (h *Health) Register(c HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, hc := range h.checks {
+		if hc.Name == c.Name {
+			panic(fmt.Sprintf("olive: health check %q is already registered", c.Name))
+		}
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	h.checks = append(h.checks, &healthCheck{HealthCheck: c})
+}
+
+// SetReady toggles whether the service is ready to receive traffic. Set it to
+// false while shutting down so that load balancers stop routing requests.
+func (h *Health) SetReady(ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.notReady = !ready
+}
+
+// Ready reports whether the service is ready to receive traffic.
+func (h *Health) Ready() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return !h.notReady
+}
+
+// Run runs the checks concurrently, only those marked Liveness if liveness is set.
+// Concurrent runs share the result of a check that is in progress.
+func (h *Health) Run(ctx context.Context, liveness bool) *HealthReport {
+	h.mu.RLock()
+	checks := make([]*healthCheck, 0, len(h.checks))
+	for _, hc := range h.checks {
+		if !liveness || hc.Liveness {
+			checks = append(checks, hc)
+		}
+	}
+	report := &HealthReport{Status: HealthPass, Ready: !h.notReady, Checks: make([]HealthResult, len(checks))}
+	h.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for i, hc := range checks {
+		wg.Add(1)
+		go func(i int, hc *healthCheck) {
+			defer wg.Done()
+			report.Checks[i] = hc.run(ctx)
+		}(i, hc)
+	}
+	wg.Wait()
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	for _, r := range report.Checks {
+		switch {
+		case r.Status == HealthPass:
+		case r.Critical:
+			report.Status = HealthFail
+		case report.Status == HealthPass:
+			report.Status = HealthWarn
+		}
+	}
+	return report
+}
+
+// run returns the cached result of the check, or the result of its run in
+// progress, starting one if there is none. The check fails if ctx is done first.
+func (hc *healthCheck) run(ctx context.Context) HealthResult {
+	hc.mu.Lock()
+	if time.Now().Before(hc.expires) {
+		defer hc.mu.Unlock()
+		return hc.last
+	}
+	f := hc.flight
+	if f == nil {
+		f = &healthFlight{done: make(chan struct{})}
+		hc.flight = f
+		// the run is shared, so it isn't bound to ctx
+		go hc.check(context.Background(), f)
+	}
+	hc.mu.Unlock()
+	select {
+	case <-f.done:
+		return f.res
+	case <-ctx.Done():
+		return HealthResult{
+			Name:     hc.Name,
+			Status:   HealthFail,
+			Critical: hc.Critical,
+			Error:    ctx.Err().Error(),
+			Checked:  time.Now(),
+		}
+	}
+}
+
+// check runs the check with its timeout and records the result. It returns once
+// the timeout expires even if the check ignores ctx.
+func (hc *healthCheck) check(ctx context.Context, f *healthFlight) {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		done <- hc.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	f.res = HealthResult{
+		Name:     hc.Name,
+		Status:   HealthPass,
+		Critical: hc.Critical,
+		Duration: float64(time.Since(start)) / float64(time.Millisecond),
+		Checked:  start,
+	}
+	if err != nil {
+		f.res.Status, f.res.Error = HealthFail, err.Error()
+	}
+	hc.mu.Lock()
+	hc.last, hc.expires, hc.flight = f.res, start.Add(hc.CacheFor), nil
+	hc.mu.Unlock()
+	close(f.done)
+}
+
+// Health returns the registry of the Olive's health checks, which is shared with its groups.
+func (o *Olive) Health() *Health {
+	return o.health
+}
+
+// ServeHealth registers GET endpoints serving HealthReports at the paths. They
+// respond with 503 Service Unavailable if a critical check fails, and the
+// readiness endpoint also if the service isn't ready.
+func (o *Olive) ServeHealth(paths HealthPaths) {
+	if paths.Health == "" {
+		paths.Health = "/healthz"
+	}
+	if paths.Liveness == "" {
+		paths.Liveness = "/livez"
+	}
+	if paths.Readiness == "" {
+		paths.Readiness = "/readyz"
+	}
+	serve := func(liveness, readiness bool) func(Response) {
+		return func(r Response) {
+			report := o.health.Run(r.Context(), liveness)
+			r.Header().Set("Cache-Control", "no-store")
+			if report.Status == HealthFail || (readiness && !report.Ready) {
+				r.WriteHeader(http.StatusServiceUnavailable)
+			}
+			r.Encode(report)
+		}
+	}
+	o.Get(paths.Health, o.Endpoint(serve(false, false)).Returns(HealthReport{}))
+	o.Get(paths.Liveness, o.Endpoint(serve(true, false)).Returns(HealthReport{}))
+	o.Get(paths.Readiness, o.Endpoint(serve(false, true)).Returns(HealthReport{}))
+}
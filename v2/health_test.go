@@ -0,0 +1,135 @@
+package olive_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func pass(ctx context.Context) error { return nil }
+
+func TestHealthStatus(t *testing.T) {
+	var cacheFail, queueFail atomic.Bool
+	o := olive.Martini()
+	o.Health().Register(olive.HealthCheck{Name: "db", Check: pass, Critical: true, Liveness: true})
+	o.Health().Register(olive.HealthCheck{Name: "cache", Check: func(ctx context.Context) error {
+		if cacheFail.Load() {
+			return errors.New("cache down")
+		}
+		return nil
+	}})
+	o.Health().Register(olive.HealthCheck{Name: "queue", Critical: true, Check: func(ctx context.Context) error {
+		if queueFail.Load() {
+			panic("queue down")
+		}
+		return nil
+	}})
+	o.ServeHealth(olive.HealthPaths{})
+	c := olivetest.New(t, o)
+
+	report := func(path string, status int) olive.HealthReport {
+		t.Helper()
+		var r olive.HealthReport
+		c.Get(path).Send().
+			ExpectStatus(status).
+			ExpectHeader("Cache-Control", "no-store").
+			Decode(&r)
+		return r
+	}
+	if r := report("/healthz", http.StatusOK); r.Status != olive.HealthPass || len(r.Checks) != 3 || r.Checks[0].Name != "cache" {
+		t.Errorf("passing report is %+v", r)
+	}
+	cacheFail.Store(true)
+	r := report("/healthz", http.StatusOK)
+	if r.Status != olive.HealthWarn || r.Checks[0].Status != olive.HealthFail || r.Checks[0].Error != "cache down" {
+		t.Errorf("report with a failing non-critical check is %+v", r)
+	}
+	queueFail.Store(true)
+	if r := report("/healthz", http.StatusServiceUnavailable); r.Status != olive.HealthFail || r.Checks[2].Error != "check panicked: queue down" {
+		t.Errorf("report with a failing critical check is %+v", r)
+	}
+	if r := report("/livez", http.StatusOK); r.Status != olive.HealthPass || len(r.Checks) != 1 || r.Checks[0].Name != "db" {
+		t.Errorf("liveness report is %+v", r)
+	}
+	report("/readyz", http.StatusServiceUnavailable)
+
+	queueFail.Store(false)
+	cacheFail.Store(false)
+	if r := report("/readyz", http.StatusOK); !r.Ready {
+		t.Errorf("readiness report is %+v", r)
+	}
+	o.Health().SetReady(false)
+	if r := report("/readyz", http.StatusServiceUnavailable); r.Ready || r.Status != olive.HealthPass {
+		t.Errorf("readiness report while not ready is %+v", r)
+	}
+	report("/healthz", http.StatusOK)
+	report("/livez", http.StatusOK)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	var calls atomic.Int32
+	stuck := make(chan struct{})
+	defer close(stuck)
+	h := new(olive.Health)
+	h.Register(olive.HealthCheck{Name: "stuck", Timeout: timeout, Check: func(ctx context.Context) error {
+		calls.Add(1)
+		<-stuck
+		return nil
+	}})
+
+	// concurrent probes share the run of the check rather than waiting for each other
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := h.Run(context.Background(), false)
+			if r.Status != olive.HealthWarn || r.Checks[0].Error != context.DeadlineExceeded.Error() {
+				t.Errorf("report of a timed out check is %+v", r)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 5*timeout {
+		t.Errorf("probes took %s with a check timeout of %s", elapsed, timeout)
+	}
+	if n := calls.Load(); n > 2 {
+		t.Errorf("check ran %d times for concurrent probes", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := h.Run(ctx, false); r.Checks[0].Error != context.Canceled.Error() {
+		t.Errorf("report of a canceled run is %+v", r)
+	}
+}
+
+func TestHealthCheckCache(t *testing.T) {
+	var calls atomic.Int32
+	h := new(olive.Health)
+	h.Register(olive.HealthCheck{Name: "db", CacheFor: time.Hour, Check: func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	}})
+	for i := 0; i < 3; i++ {
+		h.Run(context.Background(), false)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("cached check ran %d times", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registered a check with a duplicate name")
+		}
+	}()
+	h.Register(olive.HealthCheck{Name: "db", Check: pass})
+}
@@ -0,0 +1,68 @@
+package olive
+
+import (
+	"github.com/go-martini/martini"
+)
+
+// A HookPoint names a position in an Endpoint's handler chain where hooks can run.
+// Hooks are martini.Handlers and may be injected with anything available at
+// their position, including the olive.Response, the request's log.Logger,
+// the context.Context and, after decoding, the pointer to the decoded parameter.
+// Like any handler, a hook may call Abort on the olive.Response to fail the request.
+type HookPoint int
+
+const (
+	// BeforeDecode hooks run before the request parameter is decoded.
+	BeforeDecode HookPoint = iota
+
+	// AfterDecode hooks run after the request parameter is decoded and injected.
+	AfterDecode
+
+	// BeforeHandler hooks run after group middleware, immediately before the endpoint's handlers.
+	BeforeHandler
+
+	// AfterHandler hooks run after the endpoint's handlers return without aborting.
+	AfterHandler
+)
+
+// hooks maps each HookPoint to the handlers run there
+type hooks map[HookPoint][]martini.Handler
+
+func (h hooks) add(p HookPoint, hs ...martini.Handler) hooks {
+	if h == nil {
+		h = make(hooks)
+	}
+	h[p] = append(h[p][:len(h[p]):len(h[p])], hs...)
+	return h
+}
+
+func (h hooks) copy() hooks {
+	c := make(hooks, len(h))
+	for p, hs := range h {
+		c[p] = hs[:len(hs):len(hs)]
+	}
+	return c
+}
+
+// Hook registers handlers to run at point p in every Endpoint the Olive creates.
+//
+//	o.Hook(olive.AfterDecode, func(r olive.Response, in *CreateAccountParam) {
+//		if in.Email == "" {
+//			r.Abort(&olive.Error{StatusCode: 422, Message: "email is required"})
+//		}
+//	})
+func (o *Olive) Hook(p HookPoint, hs ...martini.Handler) {
+	o.hooks = o.hooks.add(p, hs...)
+}
+
+// afterHandlerMiddleware runs the hooks after the rest of the chain has returned
+func afterHandlerMiddleware(hs []martini.Handler) martini.Handler {
+	return func(c martini.Context) {
+		c.Next()
+		for _, h := range hs {
+			if _, err := c.Invoke(h); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package olive_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestHookOrderAndAbort(t *testing.T) {
+	var ran []string
+	// step returns a handler recording name, aborting if the request's abort query parameter is name
+	step := func(name string) func(olive.Response, *http.Request) {
+		return func(r olive.Response, req *http.Request) {
+			ran = append(ran, name)
+			if req.URL.Query().Get("abort") == name {
+				r.Abort(&olive.Error{StatusCode: http.StatusTeapot, Message: name})
+			}
+		}
+	}
+	o := olive.Martini()
+	o.Hook(olive.BeforeDecode, step("before-decode"))
+	o.Hook(olive.AfterDecode, func(m *echoMessage) { ran = append(ran, "after-decode:"+m.Msg) })
+	o.Hook(olive.AfterDecode, step("after-decode"))
+	g := o.Group("/g", step("group"))
+	g.Hook(olive.AfterHandler, step("after-handler"))
+	o.Hook(olive.AfterHandler, step("not-in-group"))
+	g.Post("/echo", g.Endpoint(func(r olive.Response, m *echoMessage, req *http.Request) {
+		step("handler")(r, req)
+		if req.URL.Query().Get("abort") == "panic" {
+			panic("boom")
+		}
+		r.Encode(m)
+	}).Param(echoMessage{}).
+		Hook(olive.BeforeDecode, step("endpoint-before-decode")).
+		Hook(olive.BeforeHandler, step("before-handler")))
+	c := olivetest.New(t, o)
+
+	for _, tc := range []struct {
+		abort  string
+		body   string
+		status int
+		ran    []string
+	}{
+		{"", `{"msg":"hi"}`, http.StatusOK,
+			[]string{"group", "before-decode", "endpoint-before-decode", "after-decode:hi", "after-decode", "before-handler", "handler", "after-handler"}},
+		{"group", `{"msg":`, http.StatusTeapot,
+			[]string{"group"}},
+		{"before-decode", `{"msg":`, http.StatusTeapot,
+			[]string{"group", "before-decode"}},
+		{"", `{"msg":`, http.StatusBadRequest,
+			[]string{"group", "before-decode", "endpoint-before-decode"}},
+		{"after-decode", `{"msg":"hi"}`, http.StatusTeapot,
+			[]string{"group", "before-decode", "endpoint-before-decode", "after-decode:hi", "after-decode"}},
+		{"before-handler", `{"msg":"hi"}`, http.StatusTeapot,
+			[]string{"group", "before-decode", "endpoint-before-decode", "after-decode:hi", "after-decode", "before-handler"}},
+		{"handler", `{"msg":"hi"}`, http.StatusTeapot,
+			[]string{"group", "before-decode", "endpoint-before-decode", "after-decode:hi", "after-decode", "before-handler", "handler"}},
+		{"panic", `{"msg":"hi"}`, http.StatusInternalServerError,
+			[]string{"group", "before-decode", "endpoint-before-decode", "after-decode:hi", "after-decode", "before-handler", "handler"}},
+		{"after-handler", `{"msg":"hi"}`, http.StatusOK,
+			[]string{"group", "before-decode", "endpoint-before-decode", "after-decode:hi", "after-decode", "before-handler", "handler", "after-handler"}},
+	} {
+		ran = nil
+		c.Post("/g/echo", tc.body).Query("abort", tc.abort).Send().ExpectStatus(tc.status)
+		if !reflect.DeepEqual(ran, tc.ran) {
+			t.Errorf("abort at %q with body %s ran %v, want %v", tc.abort, tc.body, ran, tc.ran)
+		}
+	}
+}
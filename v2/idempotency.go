@@ -0,0 +1,236 @@
+package olive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	log "github.com/inconshreveable/log15/v3"
+)
+
+// An IdempotencyPolicy makes an Endpoint's POST and PATCH requests safe to retry.
+// The first response to a request with an Idempotency-Key header is stored and
+// replayed to retries with the same key. A retry while the first request is in
+// flight is rejected with 409 Conflict, and reusing a key with a different body
+// is rejected with 422 Unprocessable Entity.
+//
+//	orders := &olive.IdempotencyPolicy{TTL: 24 * time.Hour}
+//	o.Post("/orders", o.Endpoint(createOrder).Param(Order{}).Idempotency(orders))
+//
+// Keys are scoped to the method, path and authenticated principal, or the client's
+// IP address if the request isn't authenticated. Request bodies are fingerprinted
+// up to the endpoint's MaxBodySize, or 10MB if it has none. Responses
+// with a 5xx status aren't stored so that the request can be retried. Headers
+// set for each request, such as those of CORS and rate limits, aren't stored
+// and are set afresh on replays.
+type IdempotencyPolicy struct {
+	TTL      time.Duration // how long responses are replayed, defaults to 24 hours
+	Header   string        // request header with the key, defaults to Idempotency-Key
+	Required bool          // reject requests without a key with 400 Bad Request
+
+	// where keys and responses are stored, defaults to an in-memory store
+	Store IdempotencyStore
+
+	once sync.Once
+}
+
+// An IdempotencyRecord is the state of an idempotency key.
+type IdempotencyRecord struct {
+	Fingerprint string          // hash of the request body
+	Response    *CachedResponse // nil while the request is in flight
+}
+
+// An IdempotencyStore stores the state of idempotency keys. Implementations
+// must be safe for concurrent use and Begin must be atomic.
+type IdempotencyStore interface {
+	// Begin records that a request with the key and fingerprint is in flight and
+	// returns true, unless the key is already stored, in which case it returns
+	// the stored record and false.
+	Begin(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error)
+
+	// Complete stores the response to the request with the key.
+	Complete(key string, resp *CachedResponse, ttl time.Duration) error
+
+	// Abandon forgets a key whose request didn't produce a response to replay.
+	Abandon(key string) error
+}
+
+// idempotencyMaxBody bounds the body read to fingerprint a request to an
+// endpoint without a body size limit
+const idempotencyMaxBody = 10 << 20
+
+func (p *IdempotencyPolicy) init() {
+	p.once.Do(func() {
+		if p.TTL == 0 {
+			p.TTL = 24 * time.Hour
+		}
+		if p.Header == "" {
+			p.Header = "Idempotency-Key"
+		}
+		if p.Store == nil {
+			p.Store = NewMemoryIdempotencyStore()
+		}
+	})
+}
+
+// idempotencyMiddleware replays stored responses to retried requests and
+// stores the responses produced by the rest of the chain.
+func idempotencyMiddleware(p *IdempotencyPolicy, maxBody int64) martini.Handler {
+	return func(c martini.Context, req *http.Request, w http.ResponseWriter, e *errEncoder, l log.Logger) {
+		if p == nil || (req.Method != http.MethodPost && req.Method != http.MethodPatch) {
+			return
+		}
+		p.init()
+		idemKey := req.Header.Get(p.Header)
+		if idemKey == "" {
+			if p.Required {
+				e.Abort(&Error{
+					StatusCode: http.StatusBadRequest,
+					Message:    "missing idempotency key",
+					Details:    M{"header": p.Header},
+				})
+			}
+			return
+		}
+
+		// read the body to fingerprint it, enforcing the endpoint's limit
+		if maxBody <= 0 {
+			maxBody = idempotencyMaxBody
+		}
+		buf, err := io.ReadAll(io.LimitReader(req.Body, maxBody+1))
+		if err != nil {
+			e.Abort(decodeFailure(err))
+		}
+		if int64(len(buf)) > maxBody {
+			e.Abort(requestTooLarge(maxBody))
+		}
+		req.Body = io.NopCloser(bytes.NewReader(buf))
+		sum := sha256.Sum256(buf)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		// keys of unauthenticated clients mustn't collide with each other's
+		scope := "client:" + KeyByIP(req, nil)
+		if pr := principal(c); pr != nil && pr.Subject() != "" {
+			scope = "principal:" + pr.Subject()
+		}
+		key := scope + "\n" + req.Method + " " + req.URL.Path + "\n" + idemKey
+		rec, begun, err := p.Store.Begin(key, fingerprint, p.TTL)
+		if err != nil {
+			e.Abort(err)
+		}
+		if !begun {
+			switch {
+			case rec.Fingerprint != fingerprint:
+				e.Abort(&Error{
+					StatusCode: http.StatusUnprocessableEntity,
+					Message:    "idempotency key reused with a different request",
+					Details:    M{"key": idemKey},
+				})
+			case rec.Response == nil:
+				e.Abort(&Error{
+					StatusCode: http.StatusConflict,
+					Message:    "a request with the idempotency key is in progress",
+					Details:    M{"key": idemKey},
+				})
+			}
+			l.Debug("replaying idempotent response", "key", idemKey)
+			replay(w.(martini.ResponseWriter), rec.Response)
+			return
+		}
+
+		// record the response, including errors written by aborts further down the chain
+		rw := &recorder{ResponseWriter: w.(martini.ResponseWriter)}
+		re := *e
+		re.w = rw
+		c.MapTo(rw, (*http.ResponseWriter)(nil))
+		c.Map(&re)
+		defer func() {
+			if !rw.Written() || rw.Status() >= 500 {
+				if err := p.Store.Abandon(key); err != nil {
+					l.Error("failed to abandon idempotency key", "err", err)
+				}
+				return
+			}
+			err := p.Store.Complete(key, &CachedResponse{
+				StatusCode: rw.Status(),
+				Header:     storedHeader(rw.Header()),
+				Body:       rw.body.Bytes(),
+				Stored:     time.Now(),
+			}, p.TTL)
+			if err != nil {
+				l.Error("failed to store idempotent response", "err", err)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// replay writes a stored response
+func replay(rw martini.ResponseWriter, resp *CachedResponse) {
+	h := rw.Header()
+	replayHeader(h, resp.Header)
+	h.Set("Idempotent-Replayed", "true")
+	h.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	rw.WriteHeader(resp.StatusCode)
+	rw.Write(resp.Body)
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*memoryIdempotencyRecord
+	swept   time.Time
+}
+
+type memoryIdempotencyRecord struct {
+	IdempotencyRecord
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]*memoryIdempotencyRecord)}
+}
+
+func (s *MemoryIdempotencyStore) Begin(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.swept) > time.Minute {
+		for k, r := range s.records {
+			if now.After(r.expires) {
+				delete(s.records, k)
+			}
+		}
+		s.swept = now
+	}
+	if r, ok := s.records[key]; ok && now.Before(r.expires) {
+		rec := r.IdempotencyRecord
+		return &rec, false, nil
+	}
+	s.records[key] = &memoryIdempotencyRecord{IdempotencyRecord{Fingerprint: fingerprint}, now.Add(ttl)}
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(key string, resp *CachedResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.records[key]; ok {
+		r.Response = resp
+		r.expires = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Abandon(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
@@ -0,0 +1,80 @@
+package olive_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestIdempotencyDoesNotReplayRequestHeaders(t *testing.T) {
+	o := olive.Martini()
+	o.CORS = &olive.CORSPolicy{AllowedOrigins: []string{"https://a.example.com", "https://b.example.com"}}
+	o.RateLimit = &olive.RateLimit{
+		Limiter: &olive.TokenBucket{Rate: 10, Per: time.Hour},
+		Key:     olive.KeyByIP,
+	}
+	created := 0
+	o.Post("/orders", o.Endpoint(func(r olive.Response) {
+		created++
+		r.Created("/orders/1", created)
+	}).Idempotency(&olive.IdempotencyPolicy{}))
+	c := olivetest.New(t, o)
+
+	c.Post("/orders", "").Header("Idempotency-Key", "k1").Header("Origin", "https://a.example.com").Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Access-Control-Allow-Origin", "https://a.example.com").
+		ExpectHeader("RateLimit-Remaining", "9").
+		ExpectBody(float64(1))
+	resp := c.Post("/orders", "").Header("Idempotency-Key", "k1").Header("Origin", "https://b.example.com").Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Idempotent-Replayed", "true").
+		ExpectHeader("Location", "/orders/1").
+		ExpectHeader("Access-Control-Allow-Origin", "https://b.example.com").
+		ExpectHeader("RateLimit-Remaining", "8").
+		ExpectBody(float64(1))
+	if vary := resp.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Origin" {
+		t.Errorf("Vary is %q, want Origin once", vary)
+	}
+}
+
+func TestIdempotencyKeysScopedByClient(t *testing.T) {
+	o := olive.Martini()
+	created := 0
+	o.Post("/orders", o.Endpoint(func(r olive.Response) {
+		created++
+		r.Created("/orders/1", created)
+	}).Idempotency(&olive.IdempotencyPolicy{}))
+	// the test client's address is taken from a header
+	c := olivetest.NewHandler(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.RemoteAddr = req.Header.Get("X-Addr")
+		o.ServeHTTP(w, req)
+	}))
+
+	c.Post("/orders", "").Header("Idempotency-Key", "k1").Header("X-Addr", "192.0.2.1:1000").Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectBody(float64(1))
+	c.Post("/orders", "").Header("Idempotency-Key", "k1").Header("X-Addr", "192.0.2.2:1000").Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Idempotent-Replayed", "").
+		ExpectBody(float64(2))
+	c.Post("/orders", "").Header("Idempotency-Key", "k1").Header("X-Addr", "192.0.2.1:2000").Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Idempotent-Replayed", "true").
+		ExpectBody(float64(1))
+}
+
+func TestIdempotencyLimitsBodyByDefault(t *testing.T) {
+	o := olive.Martini()
+	o.Post("/upload", o.Endpoint(func(r olive.Response) {
+		r.WriteHeader(http.StatusNoContent)
+	}).Idempotency(&olive.IdempotencyPolicy{}))
+	c := olivetest.New(t, o)
+
+	c.Post("/upload", make([]byte, 10<<20+1)).ContentType("application/octet-stream").Header("Idempotency-Key", "k1").Send().
+		ExpectError(http.StatusRequestEntityTooLarge, 0)
+	c.Post("/upload", make([]byte, 1024)).ContentType("application/octet-stream").Header("Idempotency-Key", "k2").Send().
+		ExpectStatus(http.StatusNoContent)
+}
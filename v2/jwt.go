@@ -0,0 +1,563 @@
+package olive
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// Errors returned to clients when JWT verification fails. They can be added to
+// an API's Catalog so that they are documented along with the API's own errors:
+//
+//	for _, def := range olive.JWTErrors {
+//		catalog.MustRegister(*def)
+//	}
+var (
+	ErrTokenMalformed = &ErrorDef{
+		ErrorCode:   40101,
+		StatusCode:  http.StatusUnauthorized,
+		Message:     "malformed token",
+		Description: "The bearer token is not a well-formed JSON Web Token.",
+	}
+	ErrTokenSignature = &ErrorDef{
+		ErrorCode:   40102,
+		StatusCode:  http.StatusUnauthorized,
+		Message:     "invalid token signature",
+		Description: "The token's signature could not be verified with any known key.",
+	}
+	ErrTokenExpired = &ErrorDef{
+		ErrorCode:   40103,
+		StatusCode:  http.StatusUnauthorized,
+		Message:     "token expired",
+		Description: "The token's expiry time (exp) has passed.",
+	}
+	ErrTokenNotYetValid = &ErrorDef{
+		ErrorCode:   40104,
+		StatusCode:  http.StatusUnauthorized,
+		Message:     "token not yet valid",
+		Description: "The token's not-before time (nbf) has not been reached.",
+	}
+	ErrTokenIssuer = &ErrorDef{
+		ErrorCode:   40105,
+		StatusCode:  http.StatusUnauthorized,
+		Message:     "token issuer not accepted",
+		Description: "The token was not issued (iss) by the expected issuer.",
+	}
+	ErrTokenAudience = &ErrorDef{
+		ErrorCode:   40106,
+		StatusCode:  http.StatusUnauthorized,
+		Message:     "token audience not accepted",
+		Description: "The token's audience (aud) does not include this API.",
+	}
+
+	JWTErrors = []*ErrorDef{
+		ErrTokenMalformed,
+		ErrTokenSignature,
+		ErrTokenExpired,
+		ErrTokenNotYetValid,
+		ErrTokenIssuer,
+		ErrTokenAudience,
+	}
+)
+
+// A KeySet provides the keys used to verify JWT signatures. Keys are []byte for
+// HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+type KeySet interface {
+	// Key returns the key with the key id (which may be empty) for the algorithm.
+	Key(kid, alg string) (interface{}, error)
+}
+
+// KeySetFunc adapts a function into a KeySet.
+type KeySetFunc func(kid, alg string) (interface{}, error)
+
+func (f KeySetFunc) Key(kid, alg string) (interface{}, error) {
+	return f(kid, alg)
+}
+
+// JWTAuth authenticates requests with a JSON Web Token (RFC 7519) bearer token
+// signed with HS256, RS256 or ES256. Authenticated requests are injected with a
+// *JWTPrincipal and, if Claims is set, with a pointer to the token's claims
+// decoded into a value of the same type as Claims.
+//
+//	type Claims struct {
+//		Subject string `json:"sub"`
+//		OrgID   string `json:"org_id"`
+//	}
+//
+//	keys, err := olive.LoadJWKS("/etc/api/jwks.json")
+//	o.Authenticators = []olive.Authenticator{&olive.JWTAuth{
+//		Keys:     keys,
+//		Issuer:   "https://auth.example.com/",
+//		Audience: "api",
+//		Claims:   Claims{},
+//	}}
+//	o.Get("/me", o.Endpoint(func(r olive.Response, c *Claims) { ... }))
+type JWTAuth struct {
+	Realm    string
+	Keys     KeySet
+	Issuer   string        // required issuer (iss), empty accepts any issuer
+	Audience string        // required audience (aud), empty accepts any audience
+	Leeway   time.Duration // allowed clock skew when checking exp and nbf
+	Claims   interface{}   // if set, the claims are decoded into a new value of this type and injected
+
+	// the claim listing the principal's roles, either as a space-separated string
+	// or an array of strings. Defaults to "scope".
+	RolesClaim string
+
+	// returns the current time, defaults to time.Now
+	Now func() time.Time
+}
+
+// JWTPrincipal is the Principal of a request authenticated by a JWTAuth.
+type JWTPrincipal struct {
+	Header map[string]interface{} // the token's JOSE header
+	Raw    map[string]interface{} // all of the token's claims
+	Claims interface{}            // the claims decoded into the JWTAuth's Claims type, if it has one
+	roles  []string
+}
+
+func (p *JWTPrincipal) Subject() string {
+	sub, _ := p.Raw["sub"].(string)
+	return sub
+}
+
+func (p *JWTPrincipal) HasRole(role string) bool {
+	for _, r := range p.roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *JWTPrincipal) inject(c martini.Context) {
+	if p.Claims != nil {
+		c.Map(p.Claims)
+	}
+}
+
+// validate panics if the JWTAuth has no keys to verify tokens with
+func (a *JWTAuth) validate() {
+	if a.Keys == nil {
+		panic("olive: a JWTAuth must have Keys")
+	}
+}
+
+func (a *JWTAuth) Challenge() string {
+	return fmt.Sprintf("Bearer realm=%q", a.Realm)
+}
+
+func (a *JWTAuth) Authenticate(req *http.Request) (Principal, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	return a.Verify(token)
+}
+
+// Verify checks the token's signature and claims and returns its principal.
+// Failures are reported as *Errors constructed from the ErrToken definitions.
+func (a *JWTAuth) Verify(token string) (*JWTPrincipal, error) {
+	if a.Keys == nil {
+		return nil, errors.New("olive: JWTAuth has no Keys")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed.New(M{"err": "token must have three parts"})
+	}
+	p := new(JWTPrincipal)
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed.New(M{"err": "invalid header encoding"})
+	}
+	if err := json.Unmarshal(headerJSON, &p.Header); err != nil {
+		return nil, ErrTokenMalformed.New(M{"err": "invalid header"})
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed.New(M{"err": "invalid payload encoding"})
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&p.Raw); err != nil {
+		return nil, ErrTokenMalformed.New(M{"err": "invalid claims"})
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed.New(M{"err": "invalid signature encoding"})
+	}
+
+	alg, _ := p.Header["alg"].(string)
+	kid, _ := p.Header["kid"].(string)
+	key, err := a.Keys.Key(kid, alg)
+	if err != nil {
+		return nil, ErrTokenSignature.New(M{"kid": kid, "err": err.Error()})
+	}
+	if err := verifySignature(alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, ErrTokenSignature.New(M{"alg": alg, "err": err.Error()})
+	}
+
+	if err := a.checkClaims(p.Raw); err != nil {
+		return nil, err
+	}
+	if a.Claims != nil {
+		claims := reflect.New(reflect.TypeOf(a.Claims)).Interface()
+		if err := json.Unmarshal(payload, claims); err != nil {
+			return nil, ErrTokenMalformed.New(M{"err": err.Error()})
+		}
+		p.Claims = claims
+	}
+	p.roles = rolesClaim(p.Raw, a.RolesClaim)
+	return p, nil
+}
+
+func (a *JWTAuth) checkClaims(claims map[string]interface{}) error {
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	t := now()
+	if exp, ok, err := timeClaim(claims, "exp"); err != nil {
+		return err
+	} else if ok && !t.Before(exp.Add(a.Leeway)) {
+		return ErrTokenExpired.New(M{"exp": exp.UTC().Format(time.RFC3339)})
+	}
+	if nbf, ok, err := timeClaim(claims, "nbf"); err != nil {
+		return err
+	} else if ok && t.Add(a.Leeway).Before(nbf) {
+		return ErrTokenNotYetValid.New(M{"nbf": nbf.UTC().Format(time.RFC3339)})
+	}
+	if a.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.Issuer {
+			return ErrTokenIssuer.New(M{"iss": iss})
+		}
+	}
+	if a.Audience != "" && !hasAudience(claims["aud"], a.Audience) {
+		return ErrTokenAudience.New(M{"aud": claims["aud"]})
+	}
+	return nil
+}
+
+// timeClaim reads a NumericDate claim
+func timeClaim(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, ErrTokenMalformed.New(M{"err": name + " claim must be a number"})
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, ErrTokenMalformed.New(M{"err": name + " claim must be a number"})
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true, nil
+}
+
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if s, _ := a.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func rolesClaim(claims map[string]interface{}, name string) []string {
+	if name == "" {
+		name = "scope"
+	}
+	switch v := claims[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		roles := make([]string, 0, len(v))
+		for _, r := range v {
+			if s, ok := r.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	}
+	return nil
+}
+
+// verifySignature checks the signature of the signing input. The type of the key
+// must match the algorithm so that keys can't be used with the wrong algorithm.
+func verifySignature(alg string, key interface{}, input string, sig []byte) error {
+	digest := sha256.Sum256([]byte(input))
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.New("key is not an HMAC secret")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(input))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errors.New("signature mismatch")
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key is not an RSA public key")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("signature mismatch")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return errors.New("key is not a P-256 public key")
+		}
+		if len(sig) != 64 {
+			return errors.New("signature has the wrong length")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("signature mismatch")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	return nil
+}
+
+// JWKS is a KeySet parsed from a JSON Web Key Set (RFC 7517).
+type JWKS struct {
+	keys []jwk
+}
+
+type jwk struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// ParseJWKS parses a JSON Web Key Set document. RSA, P-256 EC and symmetric
+// ("oct") keys are supported, other keys are ignored.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var doc struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	set := new(JWKS)
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var (
+			key interface{}
+			alg string
+		)
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid RSA key %q", k.Kid)
+			}
+			key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			alg = "RS256"
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+			}
+			key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			alg = "ES256"
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("invalid symmetric key %q", k.Kid)
+			}
+			key = secret
+			alg = "HS256"
+		default:
+			continue
+		}
+		if k.Alg != "" && k.Alg != alg {
+			continue
+		}
+		set.keys = append(set.keys, jwk{kid: k.Kid, alg: alg, key: key})
+	}
+	return set, nil
+}
+
+// LoadJWKS reads a JSON Web Key Set from a file.
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// Key returns the key with the key id for the algorithm. If kid is empty, the
+// set must contain exactly one key for the algorithm.
+func (s *JWKS) Key(kid, alg string) (interface{}, error) {
+	var found []interface{}
+	for _, k := range s.keys {
+		if k.alg == alg && (kid == "" || k.kid == kid) {
+			found = append(found, k.key)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no %s key with id %q", alg, kid)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("ambiguous %s key id %q", alg, kid)
+	}
+}
+
+// RemoteJWKS is a KeySet fetched from a URL. The set is cached and refetched
+// after the TTL elapses, or when a token references an unknown key. Fetches are
+// at least MinRefresh apart, also after failures, and concurrent requests share
+// a single fetch.
+type RemoteJWKS struct {
+	URL        string
+	Client     *http.Client  // defaults to a client with a 10 second timeout
+	TTL        time.Duration // how long the set is cached, defaults to an hour
+	MinRefresh time.Duration // minimum time between fetches, defaults to a minute
+
+	mu        sync.Mutex
+	set       *JWKS
+	fetched   time.Time // time of the last successful fetch
+	attempted time.Time // time of the last fetch
+	err       error     // error of the last fetch
+	inflight  *jwksFetch
+}
+
+// jwksFetch is a fetch of a RemoteJWKS that concurrent requests wait for
+type jwksFetch struct {
+	done chan struct{}
+	set  *JWKS
+	err  error
+}
+
+var defaultJWKSClient = &http.Client{Timeout: 10 * time.Second}
+
+func (r *RemoteJWKS) Key(kid, alg string) (interface{}, error) {
+	ttl, minRefresh := r.TTL, r.MinRefresh
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	if minRefresh == 0 {
+		minRefresh = time.Minute
+	}
+	r.mu.Lock()
+	set, fetched := r.set, r.fetched
+	r.mu.Unlock()
+	if set == nil || time.Since(fetched) > ttl {
+		var err error
+		if set, err = r.refresh(minRefresh); set == nil {
+			if err == nil {
+				err = fmt.Errorf("JWKS from %s hasn't been fetched", r.URL)
+			}
+			return nil, err
+		}
+	}
+	key, err := set.Key(kid, alg)
+	if err != nil {
+		// the key may have been rotated in since the set was fetched
+		if fresh, ferr := r.refresh(minRefresh); ferr == nil && fresh != set {
+			return fresh.Key(kid, alg)
+		}
+	}
+	return key, err
+}
+
+// refresh fetches the set unless a fetch was attempted within minRefresh, and
+// returns the current set and the error of the latest fetch. The lock isn't
+// held during the fetch, which concurrent callers wait for instead of starting their own.
+func (r *RemoteJWKS) refresh(minRefresh time.Duration) (*JWKS, error) {
+	r.mu.Lock()
+	if f := r.inflight; f != nil {
+		r.mu.Unlock()
+		<-f.done
+		return f.set, f.err
+	}
+	if time.Since(r.attempted) < minRefresh {
+		defer r.mu.Unlock()
+		return r.set, r.err
+	}
+	f := &jwksFetch{done: make(chan struct{})}
+	r.inflight, r.attempted = f, time.Now()
+	r.mu.Unlock()
+
+	set, err := r.fetch()
+
+	r.mu.Lock()
+	if err == nil {
+		r.set, r.fetched = set, time.Now()
+	}
+	r.err, r.inflight = err, nil
+	f.set, f.err = r.set, err
+	r.mu.Unlock()
+	close(f.done)
+	return f.set, f.err
+}
+
+func (r *RemoteJWKS) fetch() (*JWKS, error) {
+	client := r.Client
+	if client == nil {
+		client = defaultJWKSClient
+	}
+	resp, err := client.Get(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS from %s: %s", r.URL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
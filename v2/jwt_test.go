@@ -0,0 +1,118 @@
+package olive_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+)
+
+const testJWKS = `{"keys": [{"kty": "oct", "kid": "a", "k": "c2VjcmV0"}]}`
+
+func TestRemoteJWKSBacksOffAfterFailures(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	jwks := &olive.RemoteJWKS{URL: srv.URL}
+	for i := 0; i < 5; i++ {
+		if _, err := jwks.Key("a", "HS256"); err == nil {
+			t.Fatal("got a key from a failing JWKS endpoint")
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+}
+
+func TestRemoteJWKSUnknownKeysDontForceFetches(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write([]byte(testJWKS))
+	}))
+	defer srv.Close()
+
+	jwks := &olive.RemoteJWKS{URL: srv.URL}
+	if _, err := jwks.Key("a", "HS256"); err != nil {
+		t.Fatal(err)
+	}
+	for _, kid := range []string{"x", "y", "z"} {
+		if _, err := jwks.Key(kid, "HS256"); err == nil {
+			t.Fatalf("got a key for unknown key id %q", kid)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+}
+
+func TestRemoteJWKSFetchDoesNotBlockCachedKeys(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		w.Write([]byte(testJWKS))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	jwks := &olive.RemoteJWKS{URL: srv.URL, MinRefresh: time.Nanosecond}
+	if _, err := jwks.Key("a", "HS256"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a request for an unknown key refetches the set, which hangs
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jwks.Key("rotated", "HS256")
+	}()
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := jwks.Key("a", "HS256")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cached key blocked on a fetch")
+	}
+	release <- struct{}{}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+}
+
+func TestJWTAuthRequiresKeys(t *testing.T) {
+	a := &olive.JWTAuth{}
+	if _, err := a.Verify("e30.e30.c2ln"); err == nil {
+		t.Error("verified a token without keys")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registered an endpoint authenticated by a JWTAuth without keys")
+		}
+	}()
+	o := olive.Martini()
+	o.Authenticators = []olive.Authenticator{a}
+	o.Get("/me", o.Endpoint(func(r olive.Response) {}))
+}
@@ -0,0 +1,176 @@
+package olive
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/go-martini/martini"
+)
+
+// LinkStyle determines how the links added to a Response with AddLink are
+// embedded in JSON representations.
+type LinkStyle int
+
+const (
+	NoLinks      LinkStyle = iota // links are not embedded
+	HALLinks                      // embedded as HAL "_links": {"rel": {"href": "..."}}
+	JSONAPILinks                  // embedded as JSON:API "links": {"rel": "..."}
+)
+
+// trustedProxies matches the addresses of reverse proxies whose forwarding headers are trusted
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses IP addresses and CIDR ranges, panicking on invalid ones
+// because they are a programming error
+func parseTrustedProxies(addrs []string) trustedProxies {
+	nets := make(trustedProxies, 0, len(addrs))
+	for _, a := range addrs {
+		if !strings.Contains(a, "/") {
+			if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+				a += "/32"
+			} else {
+				a += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			panic(fmt.Sprintf("olive: invalid trusted proxy %q: %v", a, err))
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func (t trustedProxies) trusts(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseURL returns the scheme and host the client used to make the request. The
+// X-Forwarded-Proto and X-Forwarded-Host headers are honored only if the request
+// comes from a trusted proxy.
+func baseURL(req *http.Request, proxies trustedProxies) string {
+	scheme, host := "http", req.Host
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proxies.trusts(req.RemoteAddr) {
+		if proto := firstForwarded(req.Header.Get("X-Forwarded-Proto")); proto != "" {
+			scheme = proto
+		}
+		if h := firstForwarded(req.Header.Get("X-Forwarded-Host")); h != "" {
+			host = h
+		}
+	}
+	return scheme + "://" + host
+}
+
+// firstForwarded returns the value added by the proxy closest to the client
+func firstForwarded(v string) string {
+	v, _ = split(v, ",")
+	return strings.TrimSpace(v)
+}
+
+// urlParamRe matches the parameters of a route pattern the same way martini's URLFor does
+var urlParamRe = regexp.MustCompile(`:[^/#?()\.\\]+|\(\?P<[a-zA-Z0-9]+>.*\)`)
+
+// URLFor returns the path of the route with the given name, with the route's
+// parameters filled in by params. Params are formatted with fmt.Sprint and escaped.
+func (r *response) URLFor(name string, params ...interface{}) (string, error) {
+	var route martini.Route
+	for _, rt := range r.routes.All() {
+		if rt.GetName() == name {
+			route = rt
+			break
+		}
+	}
+	if route == nil {
+		return "", fmt.Errorf("olive: no route named %q", name)
+	}
+	pattern := route.Pattern()
+	if n := len(urlParamRe.FindAllStringIndex(pattern, -1)); n != len(params) {
+		return "", fmt.Errorf("olive: route %q has %d parameters, got %d", name, n, len(params))
+	}
+	i := 0
+	return urlParamRe.ReplaceAllStringFunc(pattern, func(string) string {
+		p := url.PathEscape(fmt.Sprint(params[i]))
+		i++
+		return p
+	}), nil
+}
+
+// AbsoluteURLFor is like URLFor but returns an absolute URL with the scheme
+// and host the client used to make the request.
+func (r *response) AbsoluteURLFor(name string, params ...interface{}) (string, error) {
+	path, err := r.URLFor(name, params...)
+	if err != nil {
+		return "", err
+	}
+	return baseURL(r.req, r.proxies) + path, nil
+}
+
+func (r *response) AddLink(rel, href string) {
+	if r.links == nil {
+		r.links = make(map[string]string)
+	}
+	r.links[rel] = href
+}
+
+func (r *response) Created(location string, v interface{}) error {
+	r.Header().Set("Location", location)
+	r.status = http.StatusCreated
+	return r.Encode(v)
+}
+
+// embedLinks returns v with the response's links added to its JSON object. If
+// there are none, the representation isn't JSON or v doesn't encode to a JSON
+// object, v is returned unchanged.
+func (r *response) embedLinks(v interface{}) interface{} {
+	if r.linkStyle == NoLinks || len(r.links) == 0 || !strings.Contains(r.enc.ContentType, "json") {
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil || obj == nil {
+		return v
+	}
+	links := make(M, len(r.links))
+	for rel, href := range r.links {
+		switch r.linkStyle {
+		case HALLinks:
+			links[rel] = M{"href": href}
+		case JSONAPILinks:
+			links[rel] = href
+		}
+	}
+	key := "links"
+	if r.linkStyle == HALLinks {
+		key = "_links"
+	}
+	m := make(M, len(obj)+1)
+	for k, raw := range obj {
+		m[k] = raw
+	}
+	m[key] = links
+	return m
+}
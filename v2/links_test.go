@@ -0,0 +1,54 @@
+package olive_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+type accountID struct{ n int }
+
+func (id accountID) String() string { return "ac_" + string(rune('0'+id.n)) }
+
+func TestURLFor(t *testing.T) {
+	o := olive.Martini()
+	o.Get("/accounts/:id/keys/:key", o.Endpoint(func(r olive.Response) {})).Name("key")
+	o.Get("/urls", o.Endpoint(func(r olive.Response) {
+		urls := make(map[string]string)
+		for name, params := range map[string][]interface{}{
+			"int64":    {int64(42), 7},
+			"stringer": {accountID{3}, "k"},
+			"escaped":  {"a/b?c#d", "x y"},
+		} {
+			u, err := r.URLFor("key", params...)
+			if err != nil {
+				r.Abort(err)
+			}
+			urls[name] = u
+		}
+		if _, err := r.URLFor("nope"); err == nil {
+			r.Abort(&olive.Error{StatusCode: http.StatusInternalServerError, Message: "unknown route name accepted"})
+		}
+		if _, err := r.URLFor("key", 1); err == nil {
+			r.Abort(&olive.Error{StatusCode: http.StatusInternalServerError, Message: "missing parameter accepted"})
+		}
+		abs, err := r.AbsoluteURLFor("key", 1, 2)
+		if err != nil {
+			r.Abort(err)
+		}
+		urls["absolute"] = abs
+		r.Encode(urls)
+	}))
+	c := olivetest.New(t, o)
+
+	c.Get("/urls").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(map[string]string{
+			"int64":    "/accounts/42/keys/7",
+			"stringer": "/accounts/ac_3/keys/k",
+			"escaped":  "/accounts/a%2Fb%3Fc%23d/keys/x%20y",
+			"absolute": "http://example.com/accounts/1/keys/2",
+		})
+}
@@ -0,0 +1,125 @@
+package olive
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-martini/martini"
+)
+
+// A MessageBundle provides translations of user-facing messages. Messages are
+// looked up by their untranslated (English) text, or by the message template
+// for errors constructed from an ErrorDef.
+type MessageBundle interface {
+	// Languages returns the language tags the bundle has translations for.
+	Languages() []string
+
+	// Translate returns the translation of msg into the language lang.
+	Translate(lang, msg string) (string, bool)
+}
+
+// Messages is a MessageBundle backed by a map from language tag to
+// a map of untranslated messages to their translations.
+//
+//	o.Messages = olive.Messages{
+//		"de": {
+//			"unsupported request Accept header": "nicht unterstützter Accept-Header",
+//			"account {id} not found":            "Konto {id} nicht gefunden",
+//		},
+//	}
+type Messages map[string]map[string]string
+
+func (m Messages) Languages() []string {
+	langs := make([]string, 0, len(m))
+	for lang := range m {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+func (m Messages) Translate(lang, msg string) (string, bool) {
+	t, ok := m[lang][msg]
+	return t, ok
+}
+
+// locale is the language negotiated for a request
+type locale struct {
+	lang string
+	msgs MessageBundle
+}
+
+func (l *locale) translate(msg string) (string, bool) {
+	if l == nil || l.lang == "" {
+		return "", false
+	}
+	return l.msgs.Translate(l.lang, msg)
+}
+
+// localeMiddleware negotiates the language of the response from the Accept-Language header
+func localeMiddleware(msgs MessageBundle) martini.Handler {
+	return func(r *http.Request, w http.ResponseWriter, c martini.Context) {
+		loc := &locale{msgs: msgs}
+		if msgs != nil {
+			w.Header().Add("Vary", "Accept-Language")
+			loc.lang = negotiateLanguage(r.Header.Get("Accept-Language"), msgs.Languages())
+		}
+		c.Map(loc)
+		c.Map(r.WithContext(context.WithValue(r.Context(), localeKey{}, loc)))
+	}
+}
+
+// localeKey is the key of the request's *locale in its context
+type localeKey struct{}
+
+// negotiateLanguage picks the best of the available language tags for an
+// Accept-Language header. A requested tag matches an available tag if they are
+// equal or if one is a prefix of the other (e.g. "de-AT" and "de"). It returns
+// the empty string if none of the available languages are acceptable.
+func negotiateLanguage(header string, available []string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	prefs := make([]pref, 0)
+	for _, field := range strings.Split(header, ",") {
+		tag, params := split(field, ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if k, v := split(params, "="); k == "q" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		if p.tag == "*" && len(available) > 0 {
+			return available[0]
+		}
+		// exact matches are preferred over prefix matches
+		for _, lang := range available {
+			if strings.ToLower(lang) == p.tag {
+				return lang
+			}
+		}
+		for _, lang := range available {
+			l := strings.ToLower(lang)
+			if strings.HasPrefix(p.tag, l+"-") || strings.HasPrefix(l, p.tag+"-") {
+				return lang
+			}
+		}
+	}
+	return ""
+}
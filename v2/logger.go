@@ -1,6 +1,7 @@
 package olive
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -9,9 +10,22 @@ import (
 	logext "github.com/inconshreveable/log15/v3/ext"
 )
 
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx that makes the loggers of requests made with
+// it children of l instead of log15's root logger, for example to capture the
+// log lines of a request in a test.
+func WithLogger(ctx context.Context, l log.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
 func loggerMiddleware(c martini.Context, req *http.Request, w http.ResponseWriter) {
 	start := time.Now()
-	l := log.New("pg", req.URL.Path, "id", logext.RandId(8))
+	parent, ok := req.Context().Value(loggerKey{}).(log.Logger)
+	if !ok {
+		parent = log.Root()
+	}
+	l := parent.New("pg", req.URL.Path, "id", logext.RandId(8))
 	c.MapTo(l, (*log.Logger)(nil))
 	l.Info("start")
 	c.Next()
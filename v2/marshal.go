@@ -1,6 +1,7 @@
 package olive
 
 import (
+	"errors"
 	"net/http"
 	"reflect"
 	"strconv"
@@ -11,8 +12,15 @@ import (
 	log "github.com/inconshreveable/log15/v3"
 )
 
-func unmarshalMiddleware(decoders map[string]Decoder, inputParam interface{}) martini.Handler {
-	return func(r *http.Request, c martini.Context, e *errEncoder) {
+func unmarshalMiddleware(decoders map[string]Decoder, inputParam interface{}, maxBody int64, paginated bool) martini.Handler {
+	return func(r *http.Request, w http.ResponseWriter, c martini.Context, e *errEncoder) {
+		if maxBody > 0 {
+			if r.ContentLength > maxBody {
+				e.Abort(requestTooLarge(maxBody))
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+
 		// skip if there's no input
 		if !reflect.ValueOf(inputParam).IsValid() {
 			return
@@ -21,9 +29,16 @@ func unmarshalMiddleware(decoders map[string]Decoder, inputParam interface{}) ma
 		// copy param
 		paramPtr := reflect.New(reflect.ValueOf(inputParam).Type()).Interface()
 
-		// GET handlers always pull their parameters from the URL
+		// GET handlers always pull their parameters from the URL, except those
+		// bound to the *Page of a paginated endpoint
 		if r.Method == "GET" {
-			err := param.Parse(r.URL.Query(), paramPtr)
+			query := r.URL.Query()
+			if paginated {
+				for _, k := range pageParams {
+					query.Del(k)
+				}
+			}
+			err := param.Parse(query, paramPtr)
 			if err != nil {
 				e.Abort(decodeFailure(err))
 			}
@@ -41,7 +56,10 @@ func unmarshalMiddleware(decoders map[string]Decoder, inputParam interface{}) ma
 				e.Abort(unsupportedMediaType(ct, decoders))
 			}
 			err := dec.Decode(r.Body, paramPtr)
-			if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				e.Abort(requestTooLarge(tooLarge.Limit))
+			} else if err != nil {
 				e.Abort(decodeFailure(err))
 				return
 			}
@@ -58,6 +76,14 @@ func decodeFailure(err error) *Error {
 	}
 }
 
+func requestTooLarge(limit int64) *Error {
+	return &Error{
+		StatusCode: http.StatusRequestEntityTooLarge,
+		Message:    "request body too large",
+		Details:    M{"limit": limit},
+	}
+}
+
 func unsupportedMediaType(contentType string, decoderMap map[string]Decoder) *Error {
 	available := make([]string, 0)
 	for k, _ := range decoderMap {
@@ -79,7 +105,7 @@ func unsupportedMediaTypeCE(encoding string) *Error {
 }
 
 func marshalMiddleware(encoders []ContentEncoder) martini.Handler {
-	return func(w http.ResponseWriter, r *http.Request, c martini.Context, l log.Logger) {
+	return func(w http.ResponseWriter, r *http.Request, c martini.Context, l log.Logger, loc *locale) {
 		accept := r.Header.Get("Accept")
 		if accept == "" {
 			accept = "*/*"
@@ -99,9 +125,10 @@ func marshalMiddleware(encoders []ContentEncoder) martini.Handler {
 			// error reporter injecting middleware comes after the Marshaller,
 			// so construct our own with JSON
 			w.Header().Set("Content-Type", "application/json")
-			e := errEncoder{jsonEncoder, l, w.(martini.ResponseWriter), false}
+			e := errEncoder{enc: jsonEncoder, l: l, w: w.(martini.ResponseWriter), loc: loc}
 			e.abort(notAcceptable(accept, encoders))
 		}
+		c.Map(bestEncoder)
 		c.MapTo(safeEncoder(bestEncoder, l), (*Encoder)(nil))
 		w.Header().Set("Content-Type", bestEncoder.ContentType)
 	}
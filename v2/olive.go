@@ -2,7 +2,8 @@ package olive
 
 import (
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-martini/martini"
 )
@@ -18,46 +19,98 @@ type ContentEncoder struct {
 // Olive creates API Endpoints. Customizing the properties of the Olive
 // changes the defaults of the created Endpoints.
 type Olive struct {
-	rt       martini.Router
-	Encoders []ContentEncoder   // default set of ContentEncoders used by a new Endpoint
-	Decoders map[string]Decoder // default map of Decoders used by a new Endpoint
-	Debug    bool               // default debug flag of a new Endpoint
+	rt          martini.Router
+	handler     http.Handler // serves rt with the middleware of its Martini, nil if unknown
+	routes      *routeTable
+	prefix      string
+	middleware  []martini.Handler
+	hooks       hooks
+	health      *Health
+	Encoders    []ContentEncoder   // default set of ContentEncoders used by a new Endpoint
+	Decoders    map[string]Decoder // default map of Decoders used by a new Endpoint
+	Debug       bool               // default debug flag of a new Endpoint
+	Timeout     time.Duration      // default request timeout of a new Endpoint, zero means no timeout
+	MaxBodySize int64              // default limit on the request body size of a new Endpoint, zero means no limit
+
+	// default ErrorMappers of a new Endpoint, consulted in order when
+	// translating errors passed to Abort
+	ErrorMappers []ErrorMapper
+
+	// default translations of error messages for a new Endpoint, nil disables localization
+	Messages MessageBundle
+
+	// default handler invoked with the *Panic injected when a new Endpoint recovers
+	// from a panic, nil uses a handler that responds with a 500 *Error
+	PanicHandler martini.Handler
+
+	// default CrashReporters notified when a new Endpoint recovers from a panic
+	CrashReporters []CrashReporter
+
+	// default Cross-Origin Resource Sharing policy of a new Endpoint, nil disables CORS
+	CORS *CORSPolicy
+
+	// default Authenticators of a new Endpoint, tried in order. If there are any,
+	// requests must be authenticated.
+	Authenticators []Authenticator
+
+	// default roles a new Endpoint requires the authenticated principal to have
+	RequiredRoles []string
+
+	// default rate limit of a new Endpoint, nil disables rate limiting
+	RateLimit *RateLimit
+
+	// default ETag generation of a new Endpoint
+	ETags ETagMode
+
+	// default of whether a new Endpoint requires PUT, PATCH and DELETE requests to be
+	// conditional (If-Match or If-Unmodified-Since), failing them with 428 otherwise
+	RequirePreconditions bool
+
+	// default style in which a new Endpoint embeds the links added with Response.AddLink
+	Links LinkStyle
+
+	// default addresses or CIDR ranges of the reverse proxies whose X-Forwarded-Proto and
+	// X-Forwarded-Host headers a new Endpoint trusts when building absolute URLs
+	TrustedProxies []string
 }
 
-func (o *Olive) fwd(rtfn func(string, ...martini.Handler) martini.Route, pattern string, e Endpoint) martini.Route {
-	return rtfn(pattern, e.Handlers()...)
+func (o *Olive) fwd(method string, pattern string, e Endpoint) martini.Route {
+	pattern = o.prefix + pattern
+	rt := o.rt.AddRoute(method, pattern, e.Handlers()...)
+	o.routes.add(method, pattern, rt, e)
+	return rt
 }
 
 func (o *Olive) Get(pattern string, e Endpoint) martini.Route {
-	return o.fwd(o.rt.Get, pattern, e)
+	return o.fwd(http.MethodGet, pattern, e)
 }
 
 func (o *Olive) Post(pattern string, e Endpoint) martini.Route {
-	return o.fwd(o.rt.Post, pattern, e)
+	return o.fwd(http.MethodPost, pattern, e)
 }
 
 func (o *Olive) Put(pattern string, e Endpoint) martini.Route {
-	return o.fwd(o.rt.Put, pattern, e)
+	return o.fwd(http.MethodPut, pattern, e)
 }
 
 func (o *Olive) Patch(pattern string, e Endpoint) martini.Route {
-	return o.fwd(o.rt.Patch, pattern, e)
+	return o.fwd(http.MethodPatch, pattern, e)
 }
 
 func (o *Olive) Delete(pattern string, e Endpoint) martini.Route {
-	return o.fwd(o.rt.Delete, pattern, e)
+	return o.fwd(http.MethodDelete, pattern, e)
 }
 
 func (o *Olive) Options(pattern string, e Endpoint) martini.Route {
-	return o.fwd(o.rt.Options, pattern, e)
+	return o.fwd(http.MethodOptions, pattern, e)
 }
 
 func (o *Olive) Head(pattern string, e Endpoint) martini.Route {
-	return o.fwd(o.rt.Head, pattern, e)
+	return o.fwd(http.MethodHead, pattern, e)
 }
 
 func (o *Olive) Any(pattern string, e Endpoint) martini.Route {
-	return o.fwd(o.rt.Any, pattern, e)
+	return o.fwd("*", pattern, e)
 }
 
 // Returns a new Olive API creating endpoints that can be mapped onto
@@ -69,7 +122,9 @@ func (o *Olive) Any(pattern string, e Endpoint) martini.Route {
 //	rt.Get(e.Handlers()...)
 func New(rt martini.Router) *Olive {
 	o := &Olive{
-		rt: rt,
+		rt:     rt,
+		routes: new(routeTable),
+		health: new(Health),
 		Encoders: []ContentEncoder{
 			{"application/json", jsonEncoder},
 			{"text/xml", xmlEncoder},
@@ -82,17 +137,42 @@ func New(rt martini.Router) *Olive {
 			"application/x-www-form-urlencoded": formDecoder,
 		},
 	}
-	rt.NotFound(o.Endpoint(noRouteHandler).Handlers()...)
+	// the chain is built on the first unmatched request so that it reflects
+	// the Olive's defaults as configured after New
+	var once sync.Once
+	var notFound []martini.Handler
+	rt.NotFound(func(c martini.Context) {
+		once.Do(func() { notFound = o.noRouteHandlers() })
+		runChain(c, notFound)
+	})
 	return o
 }
 
 func (o *Olive) Endpoint(hs ...martini.Handler) Endpoint {
 	return &endpoint{
-		rt:       o.rt,
-		decs:     o.Decoders,
-		encs:     o.Encoders,
-		debug:    o.Debug,
-		handlers: hs,
+		rt:         o.rt,
+		handler:    o.handler,
+		routes:     o.routes,
+		decs:       o.Decoders,
+		encs:       o.Encoders,
+		debug:      o.Debug,
+		timeout:    o.Timeout,
+		maxBody:    o.MaxBodySize,
+		mappers:    o.ErrorMappers,
+		msgs:       o.Messages,
+		onPanic:    o.PanicHandler,
+		crashes:    o.CrashReporters,
+		cors:       o.CORS,
+		auths:      o.Authenticators,
+		roles:      o.RequiredRoles,
+		limit:      o.RateLimit,
+		etags:      o.ETags,
+		condReq:    o.RequirePreconditions,
+		links:      o.Links,
+		proxies:    o.TrustedProxies,
+		middleware: o.middleware,
+		hooks:      o.hooks.copy(),
+		handlers:   hs,
 	}
 }
 
@@ -106,9 +186,13 @@ func (o *Olive) Endpoint(hs ...martini.Handler) Endpoint {
 //	o.Get("/tables", e.Handlers()...)
 type Endpoint interface {
 	// stucture of the request input, deserialized either from the request body or query string
-	// if set, a pointer to a value of this type will be dependency-injected into the handler
+	// if set, a pointer to a value of this type will be dependency-injected into the handler.
+	// A Patch replaces the endpoint's decoders with those of the patch media types.
 	Param(interface{}) Endpoint
 
+	// structure of the response body, describing the endpoint to generated clients
+	Returns(interface{}) Endpoint
+
 	// overload the allowed decoders
 	Decoders(map[string]Decoder) Endpoint
 
@@ -118,45 +202,216 @@ type Endpoint interface {
 	// debug determines if error stack traces are printed to the client
 	Debug(bool) Endpoint
 
+	// timeout bounds the lifetime of the request's context, zero means no timeout.
+	// Handlers aren't interrupted; they must return once the context is done, after
+	// which the request fails with 504 if nothing was written.
+	Timeout(time.Duration) Endpoint
+
+	// limit on the size of the request body in bytes, zero means no limit
+	MaxBodySize(int64) Endpoint
+
+	// customize how errors passed to Abort are translated into an *Error
+	ErrorMappers([]ErrorMapper) Endpoint
+
+	// translations of messages for the languages negotiated with the Accept-Language header
+	Messages(MessageBundle) Endpoint
+
+	// handler invoked with the *Panic injected when recovering from a panic, nil uses the default
+	PanicHandler(martini.Handler) Endpoint
+
+	// crash reporters notified when recovering from a panic
+	CrashReporters(...CrashReporter) Endpoint
+
+	// Cross-Origin Resource Sharing policy, nil disables CORS
+	CORS(*CORSPolicy) Endpoint
+
+	// require requests to be authenticated by one of the authenticators, none disables authentication
+	Authenticators(...Authenticator) Endpoint
+
+	// require the authenticated principal to have all of the roles
+	Require(roles ...string) Endpoint
+
+	// limit the rate of requests from each client, nil disables rate limiting
+	RateLimit(*RateLimit) Endpoint
+
+	// generate ETags for encoded representations and answer conditional GETs
+	ETags(ETagMode) Endpoint
+
+	// require PUT, PATCH and DELETE requests to be conditional
+	RequirePreconditions(bool) Endpoint
+
+	// cache successful GET responses on the server, nil disables caching
+	Cache(*CachePolicy) Endpoint
+
+	// replay the stored responses to retried POST and PATCH requests, nil disables idempotency keys
+	Idempotency(*IdempotencyPolicy) Endpoint
+
+	// bind pagination query parameters into an injected *Page
+	Paginate(PageOptions) Endpoint
+
+	// upgrade requests to WebSocket connections injected into the handlers as a *WebSocket,
+	// nil serves plain HTTP requests
+	WebSocket(*WebSocketOptions) Endpoint
+
+	// embed links added with Response.AddLink in JSON representations
+	Links(LinkStyle) Endpoint
+
+	// reverse proxies trusted to forward the scheme and host the client used
+	TrustedProxies(...string) Endpoint
+
+	// register handlers to run at the hook point, in addition to those inherited from the Olive
+	Hook(HookPoint, ...martini.Handler) Endpoint
+
 	// returns the handlers that make up the endpoint
 	Handlers() []martini.Handler
 }
 
 type endpoint struct {
 	rt       martini.Router
+	handler  http.Handler
+	routes   *routeTable
 	param    interface{}
+	returns  interface{}
 	decs     map[string]Decoder
 	encs     []ContentEncoder
 	debug    bool
+	timeout  time.Duration
+	maxBody  int64
+	mappers  []ErrorMapper
+	msgs     MessageBundle
+	onPanic  martini.Handler
+	crashes  []CrashReporter
+	cors     *CORSPolicy
+	auths    []Authenticator
+	roles    []string
+	limit    *RateLimit
+	etags    ETagMode
+	condReq  bool
+	cache    *CachePolicy
+	idem     *IdempotencyPolicy
+	paging   *PageOptions
+	ws       *WebSocketOptions
+	links    LinkStyle
+	proxies  []string
 	handlers []martini.Handler
+
+	// middleware of the group the endpoint was created by
+	middleware []martini.Handler
+	hooks      hooks
 }
 
 func (e *endpoint) Decoders(decoders map[string]Decoder) Endpoint { e.decs = decoders; return e }
 func (e *endpoint) Encoders(encoders []ContentEncoder) Endpoint   { e.encs = encoders; return e }
-func (e *endpoint) Param(p interface{}) Endpoint                  { e.param = p; return e }
+func (e *endpoint) Returns(v interface{}) Endpoint                { e.returns = v; return e }
 func (e *endpoint) Debug(debug bool) Endpoint                     { e.debug = debug; return e }
+func (e *endpoint) Timeout(d time.Duration) Endpoint              { e.timeout = d; return e }
+func (e *endpoint) MaxBodySize(n int64) Endpoint                  { e.maxBody = n; return e }
+func (e *endpoint) ErrorMappers(m []ErrorMapper) Endpoint         { e.mappers = m; return e }
+func (e *endpoint) Messages(m MessageBundle) Endpoint             { e.msgs = m; return e }
+func (e *endpoint) PanicHandler(h martini.Handler) Endpoint       { e.onPanic = h; return e }
+func (e *endpoint) CrashReporters(r ...CrashReporter) Endpoint    { e.crashes = r; return e }
+func (e *endpoint) CORS(p *CORSPolicy) Endpoint                   { e.cors = p; return e }
+func (e *endpoint) Authenticators(a ...Authenticator) Endpoint    { e.auths = a; return e }
+func (e *endpoint) Require(roles ...string) Endpoint              { e.roles = roles; return e }
+func (e *endpoint) RateLimit(rl *RateLimit) Endpoint              { e.limit = rl; return e }
+func (e *endpoint) ETags(mode ETagMode) Endpoint                  { e.etags = mode; return e }
+func (e *endpoint) RequirePreconditions(req bool) Endpoint        { e.condReq = req; return e }
+func (e *endpoint) Cache(p *CachePolicy) Endpoint                 { e.cache = p; return e }
+func (e *endpoint) Idempotency(p *IdempotencyPolicy) Endpoint     { e.idem = p; return e }
+func (e *endpoint) Paginate(opts PageOptions) Endpoint            { e.paging = &opts; return e }
+func (e *endpoint) WebSocket(opts *WebSocketOptions) Endpoint     { e.ws = opts; return e }
+func (e *endpoint) Links(style LinkStyle) Endpoint                { e.links = style; return e }
+func (e *endpoint) TrustedProxies(addrs ...string) Endpoint       { e.proxies = addrs; return e }
+func (e *endpoint) Param(p interface{}) Endpoint {
+	e.param = p
+	if _, ok := p.(Patch); ok {
+		e.decs = patchDecoders()
+	}
+	return e
+}
+func (e *endpoint) Hook(p HookPoint, hs ...martini.Handler) Endpoint {
+	e.hooks = e.hooks.add(p, hs...)
+	return e
+}
 func (e *endpoint) Handlers() []martini.Handler {
-	return append([]martini.Handler{
+	onPanic := e.onPanic
+	if onPanic == nil {
+		onPanic = defaultPanicHandler(e.debug)
+	}
+	hs := []martini.Handler{
 		mapRoutes(e.rt),
 		loggerMiddleware,
-		defaultRecoveryMiddleware(e.debug),
+		localeMiddleware(e.msgs),
+		recoveryMiddleware(onPanic, e.crashes),
 		marshalMiddleware(e.encs),
-		errEncoderMiddleware(e.debug),
-		unmarshalMiddleware(e.decs, e.param),
-		responseMiddleware(),
-	}, e.handlers...)
+		errEncoderMiddleware(e.debug, e.mappers),
+		corsMiddleware(e.cors, e.routes),
+		contextMiddleware(e.timeout),
+		authMiddleware(e.auths, e.roles, e.limit, e.maxBody),
+		rateLimitMiddleware(e.limit),
+		preconditionMiddleware(e.condReq),
+		cacheMiddleware(e.cache, e.handler, e.rt),
+		idempotencyMiddleware(e.idem, e.maxBody),
+		responseMiddleware(e.etags, e.links, e.proxies),
+	}
+	hs = append(hs, e.middleware...)
+	hs = append(hs, e.hooks[BeforeDecode]...)
+	hs = append(hs, unmarshalMiddleware(e.decs, e.param, e.maxBody, e.paging != nil), paginationMiddleware(e.paging))
+	hs = append(hs, e.hooks[AfterDecode]...)
+	hs = append(hs, e.hooks[BeforeHandler]...)
+	if after := e.hooks[AfterHandler]; len(after) > 0 {
+		hs = append(hs, afterHandlerMiddleware(after))
+	}
+	if e.ws != nil {
+		hs = append(hs, websocketMiddleware(e.ws, e.encs, e.decs))
+	}
+	return append(hs, e.handlers...)
 }
 
-func noRouteHandler(r Response, req *http.Request, rts martini.Routes) {
-	if methods := rts.MethodsFor(req.URL.Path); len(methods) > 0 {
-		allowed := strings.Join(methods, ", ")
-		r.Header().Set("Allow", allowed)
-		r.Abort(&Error{
+// noRouteHandlers is the chain answering requests that don't match any route.
+// It leaves out the endpoint middleware, like authentication, rate limits and
+// caching, which only apply to the requests an endpoint serves.
+func (o *Olive) noRouteHandlers() []martini.Handler {
+	onPanic := o.PanicHandler
+	if onPanic == nil {
+		onPanic = defaultPanicHandler(o.Debug)
+	}
+	return []martini.Handler{
+		mapRoutes(o.rt),
+		loggerMiddleware,
+		localeMiddleware(o.Messages),
+		recoveryMiddleware(onPanic, o.CrashReporters),
+		marshalMiddleware(o.Encoders),
+		errEncoderMiddleware(o.Debug, o.ErrorMappers),
+		corsMiddleware(o.CORS, o.routes),
+		o.noRouteHandler,
+	}
+}
+
+// noRouteHandler answers requests that don't match any route. OPTIONS requests
+// for a path served by the Olive's routes are answered with the allowed methods.
+func (o *Olive) noRouteHandler(w http.ResponseWriter, req *http.Request, routes martini.Routes, e *errEncoder) {
+	if methods := routes.MethodsFor(req.URL.Path); len(methods) > 0 {
+		rts := o.routes.match(req.URL.Path)
+		allowed := allowedMethods(methods)
+		w.Header().Set("Allow", allowed)
+		if req.Method == http.MethodOptions {
+			if cts, ok := acceptedContentTypes(rts, http.MethodPatch); ok {
+				w.Header().Set("Accept-Patch", cts)
+			}
+			if cts, ok := acceptedContentTypes(rts, http.MethodPost); ok {
+				w.Header().Set("Accept-Post", cts)
+			}
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		e.Abort(&Error{
 			StatusCode: http.StatusMethodNotAllowed,
 			Details:    M{"method": req.Method, "allowed": allowed},
 		})
 	} else {
-		r.Abort(&Error{
+		e.Abort(&Error{
 			StatusCode: http.StatusNotFound,
 			Details:    M{"path": req.URL.Path},
 		})
@@ -175,6 +430,10 @@ type OliveMartini struct {
 	*martini.Martini
 	*Olive
 	Router martini.Router
+
+	mu            sync.Mutex
+	shutdownHooks []ShutdownHook
+	stop          chan struct{}
 }
 
 // Returns an *OliveMartini that has both an Olive router and *martini.Martini
@@ -183,6 +442,7 @@ func Martini() *OliveMartini {
 	m := martini.New()
 	rt := martini.NewRouter()
 	o := New(rt)
+	o.handler = m
 	m.Action(rt.Handle)
-	return &OliveMartini{m, o, rt}
+	return &OliveMartini{Martini: m, Olive: o, Router: rt, stop: make(chan struct{})}
 }
@@ -0,0 +1,479 @@
+// Package olivegen generates typed Go clients for olive APIs from the routes
+// registered on an Olive.
+//
+// The Param and response types of the endpoints are only known once the API's
+// routes are registered, so clients are generated by a small program that
+// builds the API and calls Main:
+//
+//	// gen/main.go
+//	package main
+//
+//	func main() {
+//		olivegen.Main(api.New().Olive)
+//	}
+//
+// and is run by go generate:
+//
+//	//go:generate go run ./gen -o client/client.go -package client
+//
+// The generated client has one method per endpoint. Endpoints declare the type
+// of their response body with Endpoint.Returns:
+//
+//	o.Get("/accounts/:id", o.Endpoint(getAccount).Returns(Account{})).Name("getAccount")
+//
+// generates
+//
+//	func (c *Client) GetAccount(ctx context.Context, id string, opts ...RequestOption) (*api.Account, error)
+//
+// Methods return the *olive.Error of failed requests, so callers can switch on its ErrorCode:
+//
+//	var apiErr *olive.Error
+//	if errors.As(err, &apiErr) && apiErr.ErrorCode == errAccountNotFound.ErrorCode {
+//		...
+//	}
+//
+// Routes registered with Any, WebSocket endpoints and routes with regular
+// expression patterns are skipped.
+package olivegen
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/inconshreveable/olive/v2"
+)
+
+// Options configures the generated client.
+type Options struct {
+	Package string // name of the generated package, defaults to "client"
+}
+
+// Main writes the client of the Olive's routes to the file given by the -o flag,
+// or to stdout, in the package given by the -package flag. The flags are parsed
+// from os.Args with a flag set of their own, so they don't clash with the global
+// flags of the program. It exits the process if the client can't be generated.
+func Main(o *olive.Olive) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	out := flags.String("o", "", "file to write the client to, stdout if empty")
+	pkg := flags.String("package", "client", "name of the generated package")
+	flags.Parse(os.Args[1:])
+
+	var buf bytes.Buffer
+	if err := Generate(&buf, o.Routes(), Options{Package: *pkg}); err != nil {
+		fmt.Fprintln(os.Stderr, "olivegen:", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "olivegen:", err)
+		os.Exit(1)
+	}
+}
+
+// Generate writes the source of a client with a method for each of the routes.
+func Generate(w io.Writer, routes []olive.RouteInfo, opts Options) error {
+	if opts.Package == "" {
+		opts.Package = "client"
+	}
+	g := newGenerator()
+	for _, r := range routes {
+		if err := g.method(r); err != nil {
+			return fmt.Errorf("%s %s: %w", r.Method, r.Pattern, err)
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by olivegen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", opts.Package)
+	for _, imp := range g.order {
+		if name := g.imports[imp]; name != path.Base(imp) {
+			fmt.Fprintf(&src, "\t%s %q\n", name, imp)
+		} else {
+			fmt.Fprintf(&src, "\t%q\n", imp)
+		}
+	}
+	src.WriteString(")\n")
+	src.WriteString(runtime)
+	src.Write(g.methods.Bytes())
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("generated invalid source: %w", err)
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+// packages imported by the runtime of the client
+var runtimeImports = []string{
+	"bytes",
+	"context",
+	"encoding",
+	"encoding/json",
+	"encoding/xml",
+	"fmt",
+	"io",
+	"net/http",
+	"net/url",
+	"reflect",
+	"strconv",
+	"strings",
+	"github.com/inconshreveable/olive/v2",
+}
+
+type generator struct {
+	imports map[string]string // import path to package name
+	order   []string          // import paths in the order they were added
+	pkgs    map[string]bool   // package names in use
+	names   map[string]bool   // method names in use
+	methods bytes.Buffer
+}
+
+func newGenerator() *generator {
+	g := &generator{imports: make(map[string]string), pkgs: make(map[string]bool), names: make(map[string]bool)}
+	for _, imp := range runtimeImports {
+		name := path.Base(imp)
+		if imp == "github.com/inconshreveable/olive/v2" {
+			name = "olive"
+		}
+		g.imports[imp] = name
+		g.order = append(g.order, imp)
+		g.pkgs[name] = true
+	}
+	for _, name := range runtimeNames {
+		g.pkgs[name] = true
+	}
+	return g
+}
+
+// top-level names declared by the runtime of the client that imported packages can't be named
+var runtimeNames = []string{
+	"decodeBody", "encodeBody", "encodeQuery", "encodeStruct", "encodeValue", "endpoint",
+	"escapePath", "formContentType", "formatScalar", "isJSON", "isXML", "negotiate",
+}
+
+var (
+	patchType = reflect.TypeOf(olive.Patch{})
+
+	// matches the params and globs of a route pattern the same way martini does
+	patternPartRe = regexp.MustCompile(`:([^/#?()\.\\]+)|\*\*`)
+)
+
+// method writes the client method calling the route
+func (g *generator) method(r olive.RouteInfo) error {
+	if r.Method == "*" || r.WebSocket || strings.HasPrefix(r.Pattern, "^") {
+		return nil
+	}
+
+	// types of the request parameter and response, whose packages are imported
+	// before arguments are named so that arguments don't shadow them
+	var inType string
+	if r.Param != nil && r.Param != patchType {
+		var err error
+		if inType, err = g.typeExpr(r.Param); err != nil {
+			return fmt.Errorf("param: %w", err)
+		}
+	}
+	var ret, outExpr string
+	var isStruct bool
+	if rt := r.Returns; rt != nil {
+		if rt.Kind() == reflect.Ptr {
+			rt = rt.Elem()
+		}
+		typ, err := g.typeExpr(rt)
+		if err != nil {
+			return fmt.Errorf("returns: %w", err)
+		}
+		if isStruct = rt.Kind() == reflect.Struct; isStruct {
+			ret, outExpr = "*"+typ, "out"
+		} else {
+			ret, outExpr = typ, "&out"
+		}
+	}
+
+	// arguments, named so that they don't collide with the method's variables or packages
+	used := map[string]bool{"c": true, "ctx": true, "opts": true, "out": true, "err": true}
+	for name := range g.pkgs {
+		used[name] = true
+	}
+	arg := func(name string) string {
+		id := lowerCamel(name)
+		if id == "" || token.IsKeyword(id) {
+			id += "Param"
+		}
+		for base, i := id, 2; used[id]; i++ {
+			id = base + strconv.Itoa(i)
+		}
+		used[id] = true
+		return id
+	}
+
+	// path expression and name of the method built from the pattern
+	var (
+		pathExpr []string
+		args     []string
+		words    = []string{upperCamel(strings.ToLower(r.Method))}
+		globs    int
+	)
+	literal := func(s string) {
+		if s == "" {
+			return
+		}
+		pathExpr = append(pathExpr, strconv.Quote(s))
+		for _, seg := range strings.Split(s, "/") {
+			words = append(words, upperCamel(seg))
+		}
+	}
+	last := 0
+	for _, m := range patternPartRe.FindAllStringSubmatchIndex(r.Pattern, -1) {
+		literal(r.Pattern[last:m[0]])
+		last = m[1]
+		if m[2] >= 0 {
+			name := r.Pattern[m[2]:m[3]]
+			id := arg(name)
+			args = append(args, id+" string")
+			pathExpr = append(pathExpr, "url.PathEscape("+id+")")
+			words = append(words, "By", upperCamel(name))
+		} else {
+			globs++
+			id := arg("path")
+			args = append(args, id+" string")
+			pathExpr = append(pathExpr, "escapePath("+id+")")
+			if globs == 1 {
+				words = append(words, "ByPath")
+			}
+		}
+	}
+	literal(r.Pattern[last:])
+	if len(pathExpr) == 0 {
+		pathExpr = []string{`"/"`}
+	}
+	name := strings.Join(words, "")
+	if name == words[0] {
+		name += "Root"
+	}
+	if r.Name != "" {
+		name = upperCamel(r.Name)
+	}
+	if name == "" || !token.IsIdentifier(name) {
+		return fmt.Errorf("route name %q can't be used as a method name", r.Name)
+	}
+	for base, i := name, 2; g.names[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	g.names[name] = true
+
+	// the request parameter
+	in, patch := "nil", r.Param == patchType
+	if r.Param != nil {
+		in = arg("in")
+		if patch {
+			args = append(args, in+" interface{}")
+		} else {
+			args = append(args, in+" "+inType)
+		}
+	}
+	args = append([]string{"ctx context.Context"}, args...)
+	args = append(args, "opts ...RequestOption")
+
+	w := &g.methods
+	fmt.Fprintf(w, "\n// %s calls %s %s.\n", name, r.Method, r.Pattern)
+	if ret != "" {
+		fmt.Fprintf(w, "func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(args, ", "), ret)
+		if isStruct {
+			fmt.Fprintf(w, "out := new(%s)\n", ret[1:])
+		} else {
+			fmt.Fprintf(w, "var out %s\n", ret)
+		}
+		w.WriteString("err := ")
+	} else {
+		fmt.Fprintf(w, "func (c *Client) %s(%s) error {\n", name, strings.Join(args, ", "))
+		w.WriteString("return ")
+		outExpr = "nil"
+	}
+	fmt.Fprintf(w, "c.do(ctx, &endpoint{\nmethod: %q,\npath: %s,\n", r.Method, strings.Join(pathExpr, " + "))
+	fmt.Fprintf(w, "encoders: %s,\n", stringsExpr(r.Encoders))
+	if in != "nil" && r.Method != "GET" {
+		fmt.Fprintf(w, "decoders: %s,\n", stringsExpr(r.Decoders))
+	}
+	if patch {
+		w.WriteString("patch: true,\n")
+	}
+	fmt.Fprintf(w, "}, %s, %s, opts)\n", in, outExpr)
+	switch {
+	case ret == "":
+	case isStruct:
+		w.WriteString("if err != nil {\nreturn nil, err\n}\nreturn out, nil\n")
+	default:
+		w.WriteString("return out, err\n")
+	}
+	w.WriteString("}\n")
+	return nil
+}
+
+// typeExpr returns the expression of the type in the generated package, importing its package
+func (g *generator) typeExpr(t reflect.Type) (string, error) {
+	if t.Name() != "" {
+		switch {
+		case t.PkgPath() == "":
+			return t.Name(), nil
+		case t.PkgPath() == "main":
+			return "", fmt.Errorf("type %s is declared in package main, which can't be imported", t)
+		case !token.IsExported(t.Name()):
+			return "", fmt.Errorf("type %s is unexported", t)
+		case strings.Contains(t.Name(), "["):
+			return "", fmt.Errorf("generic type %s isn't supported", t)
+		}
+		return g.qualifier(t.PkgPath()) + "." + t.Name(), nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem, err := g.typeExpr(t.Elem())
+		return "*" + elem, err
+	case reflect.Slice:
+		elem, err := g.typeExpr(t.Elem())
+		return "[]" + elem, err
+	case reflect.Array:
+		elem, err := g.typeExpr(t.Elem())
+		return fmt.Sprintf("[%d]%s", t.Len(), elem), err
+	case reflect.Map:
+		key, err := g.typeExpr(t.Key())
+		if err != nil {
+			return "", err
+		}
+		elem, err := g.typeExpr(t.Elem())
+		return fmt.Sprintf("map[%s]%s", key, elem), err
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return "interface{}", nil
+		}
+	case reflect.Struct:
+		fields := make([]string, t.NumField())
+		for i := range fields {
+			sf := t.Field(i)
+			typ, err := g.typeExpr(sf.Type)
+			if err != nil {
+				return "", err
+			}
+			if sf.Anonymous {
+				fields[i] = typ
+			} else {
+				fields[i] = sf.Name + " " + typ
+			}
+			if tag := string(sf.Tag); tag != "" && !strings.Contains(tag, "`") {
+				fields[i] += " `" + tag + "`"
+			} else if tag != "" {
+				fields[i] += " " + strconv.Quote(tag)
+			}
+		}
+		return "struct {\n" + strings.Join(fields, "\n") + "\n}", nil
+	}
+	return "", fmt.Errorf("type %s isn't supported", t)
+}
+
+// qualifier returns the name the package with the import path is imported as
+func (g *generator) qualifier(imp string) string {
+	if name, ok := g.imports[imp]; ok {
+		return name
+	}
+	base := packageName(imp)
+	name := base
+	for i := 2; g.pkgs[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	g.imports[imp] = name
+	g.order = append(g.order, imp)
+	g.pkgs[name] = true
+	return name
+}
+
+var majorVersionRe = regexp.MustCompile(`^v[0-9]+$`)
+
+// packageName guesses the name of the package with the import path, which is
+// imported with that name when it's a guess
+func packageName(imp string) string {
+	elems := strings.Split(imp, "/")
+	name := elems[len(elems)-1]
+	if majorVersionRe.MatchString(name) && len(elems) > 1 {
+		name = elems[len(elems)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".go"), "-go")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+	if name == "" || !unicode.IsLetter(rune(name[0])) || token.IsKeyword(name) {
+		name = "pkg" + name
+	}
+	return name
+}
+
+// stringsExpr returns the expression of a string slice literal
+func stringsExpr(ss []string) string {
+	quoted := make([]string, len(ss))
+	for i, s := range ss {
+		quoted[i] = strconv.Quote(s)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
+
+// words that are written in upper case in Go names
+var initialisms = map[string]bool{
+	"api": true, "html": true, "http": true, "id": true, "ip": true,
+	"json": true, "uri": true, "url": true, "uuid": true, "xml": true,
+}
+
+// nameWords splits s into the words of a Go name
+func nameWords(s string) []string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		if initialisms[w] {
+			words[i] = strings.ToUpper(w)
+		} else {
+			rs := []rune(w)
+			words[i] = string(unicode.ToUpper(rs[0])) + string(rs[1:])
+		}
+	}
+	return words
+}
+
+// upperCamel returns s as an exported Go name
+func upperCamel(s string) string {
+	name := strings.Join(nameWords(s), "")
+	if name != "" && !unicode.IsLetter(rune(name[0])) {
+		name = "N" + name
+	}
+	return name
+}
+
+// lowerCamel returns s as an unexported Go name
+func lowerCamel(s string) string {
+	words := nameWords(s)
+	if len(words) == 0 {
+		return ""
+	}
+	words[0] = strings.ToLower(words[0])
+	name := strings.Join(words, "")
+	if !unicode.IsLetter(rune(name[0])) {
+		name = "p" + name
+	}
+	return name
+}
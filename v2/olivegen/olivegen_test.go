@@ -0,0 +1,50 @@
+package olivegen_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2/olivegen"
+	"github.com/inconshreveable/olive/v2/olivegen/testdata/api"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden client")
+
+var goldenClient = filepath.Join("testdata", "client", "client.go")
+
+func TestGenerate(t *testing.T) {
+	var buf bytes.Buffer
+	if err := olivegen.Generate(&buf, api.New().Routes(), olivegen.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile(goldenClient, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(goldenClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Errorf("generated client differs from %s, run go test -update to rewrite it:\n%s", goldenClient, buf.Bytes())
+	}
+}
+
+func TestGeneratedClientCompiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling the client is slow")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	out, err := exec.Command(gobin, "vet", "./testdata/client").CombinedOutput()
+	if err != nil {
+		t.Errorf("generated client doesn't compile: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,314 @@
+package olivegen
+
+// runtime is the source of the generated client, to which its methods are appended
+const runtime = `
+// Client calls the endpoints of the API.
+type Client struct {
+	BaseURL    string       // scheme, host and path prefix of the API
+	HTTPClient *http.Client // client making the requests, http.DefaultClient if nil
+
+	// content type request bodies are encoded in and responses are requested in,
+	// application/json if empty. Endpoints that don't support it use one they do.
+	ContentType string
+
+	// headers sent with every request
+	Header http.Header
+}
+
+// NewClient returns a Client calling the API at baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: baseURL, Header: make(http.Header)}
+}
+
+// A RequestOption customizes a request.
+type RequestOption func(*http.Request)
+
+// WithHeader sets a request header, e.g. an Idempotency-Key or If-Match.
+func WithHeader(name, value string) RequestOption {
+	return func(req *http.Request) { req.Header.Set(name, value) }
+}
+
+// WithQuery adds a query parameter, e.g. the page of a paginated endpoint.
+func WithQuery(name, value string) RequestOption {
+	return func(req *http.Request) {
+		q := req.URL.Query()
+		q.Add(name, value)
+		req.URL.RawQuery = q.Encode()
+	}
+}
+
+// endpoint describes how an endpoint is called
+type endpoint struct {
+	method   string
+	path     string
+	encoders []string // content types of responses
+	decoders []string // content types of request bodies
+	patch    bool     // whether the body is a JSON merge patch or JSON patch
+}
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// do calls the endpoint with in encoded in the query string of GET requests and
+// the body of others, and decodes the response into out. Error responses are
+// returned as an *olive.Error.
+func (c *Client) do(ctx context.Context, e *endpoint, in, out interface{}, opts []RequestOption) error {
+	preferred := c.ContentType
+	if preferred == "" {
+		preferred = "application/json"
+	}
+	target := strings.TrimSuffix(c.BaseURL, "/") + e.path
+
+	var body io.Reader
+	var contentType string
+	switch {
+	case in == nil:
+	case e.method == http.MethodGet:
+		q, err := encodeQuery(in)
+		if err != nil {
+			return err
+		}
+		if len(q) > 0 {
+			target += "?" + q.Encode()
+		}
+	default:
+		if e.patch {
+			contentType = "application/merge-patch+json"
+			if _, ok := in.([]olive.PatchOp); ok {
+				contentType = "application/json-patch+json"
+			}
+		} else if contentType = negotiate(preferred, e.decoders, true); contentType == "" {
+			return fmt.Errorf("%s %s: no supported request content type in %v", e.method, e.path, e.decoders)
+		}
+		data, err := encodeBody(contentType, in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, e.method, target, body)
+	if err != nil {
+		return err
+	}
+	for k, vs := range c.Header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept := negotiate(preferred, e.encoders, false); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	respType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+
+	if resp.StatusCode >= 400 {
+		apiErr := new(olive.Error)
+		if err := decodeBody(respType, data, apiErr); err != nil || apiErr.StatusCode == 0 {
+			apiErr = &olive.Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return apiErr
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return decodeBody(respType, data, out)
+}
+
+func isJSON(ct string) bool {
+	return (ct == "application/json" || strings.HasSuffix(ct, "+json")) && !strings.HasSuffix(ct, "patch+json")
+}
+
+func isXML(ct string) bool {
+	return ct == "application/xml" || ct == "text/xml" || strings.HasSuffix(ct, "+xml")
+}
+
+// negotiate returns the preferred content type if it's supported, or else the
+// first supported one the client can encode and decode
+func negotiate(preferred string, supported []string, form bool) string {
+	for _, ct := range supported {
+		if ct == preferred {
+			return ct
+		}
+	}
+	for _, ct := range supported {
+		if isJSON(ct) || isXML(ct) || (form && ct == formContentType) {
+			return ct
+		}
+	}
+	return ""
+}
+
+func encodeBody(ct string, v interface{}) ([]byte, error) {
+	switch {
+	case ct == formContentType:
+		q, err := encodeQuery(v)
+		return []byte(q.Encode()), err
+	case isXML(ct):
+		return xml.Marshal(v)
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func decodeBody(ct string, data []byte, v interface{}) error {
+	switch {
+	case isJSON(ct):
+		return json.Unmarshal(data, v)
+	case isXML(ct):
+		return xml.Unmarshal(data, v)
+	}
+	return fmt.Errorf("unsupported response content type %q", ct)
+}
+
+// escapePath escapes each segment of a path matched by a glob
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+// encodeQuery encodes a struct the way the server parses query parameters:
+// fields are named by their param or json tags, nested structs and maps are
+// encoded as name[key] and slices as name[]. Like encoding/json, zero values
+// are only omitted from fields with the omitempty option in their json tag,
+// while nil pointers, maps and slices are always omitted. A nil struct pointer
+// encodes no parameters.
+func encodeQuery(v interface{}) (url.Values, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return make(url.Values), nil
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s can't be encoded as query parameters", rv.Type())
+	}
+	q := make(url.Values)
+	return q, encodeStruct(q, "", rv)
+}
+
+func encodeStruct(q url.Values, prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		jsonOpts := strings.Split(sf.Tag.Get("json"), ",")
+		name := sf.Tag.Get("param")
+		if name == "" {
+			name = jsonOpts[0]
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if name == "-" {
+			continue
+		}
+		if v.Field(i).IsZero() && hasOption(jsonOpts[1:], "omitempty") {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "[" + name + "]"
+		}
+		if err := encodeValue(q, name, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasOption(opts []string, opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeValue(q url.Values, key string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		q.Add(key, string(text))
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return encodeValue(q, key, v.Elem())
+	case reflect.Struct:
+		return encodeStruct(q, key, v)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("%s: map keys must be strings", key)
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := encodeValue(q, key+"["+iter.Key().String()+"]", iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			s, err := formatScalar(key, v.Index(i))
+			if err != nil {
+				return err
+			}
+			q.Add(key+"[]", s)
+		}
+		return nil
+	}
+	s, err := formatScalar(key, v)
+	if err != nil {
+		return err
+	}
+	q.Add(key, s)
+	return nil
+}
+
+func formatScalar(key string, v reflect.Value) (string, error) {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.String:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("%s: %s can't be encoded as a query parameter", key, v.Type())
+}
+`
@@ -0,0 +1,49 @@
+// Package api is an API exercising the features of olivegen, whose client is
+// generated into ../client by the olivegen tests.
+package api
+
+import (
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+)
+
+type Account struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+type ListParam struct {
+	Prefix string   `param:"prefix"`
+	Tags   []string `param:"tags"`
+}
+
+type CreateParam struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// New returns the API. Its handlers aren't implemented since only its routes are used.
+func New() *olive.OliveMartini {
+	o := olive.Martini()
+	h := func(r olive.Response) {}
+	o.Get("/", o.Endpoint(h).Returns(""))
+	o.Get("/accounts", o.Endpoint(h).Param(ListParam{}).Returns([]Account{}))
+	o.Post("/accounts", o.Endpoint(h).Param(CreateParam{}).Returns(&Account{}))
+	o.Get("/accounts/:id", o.Endpoint(h).Returns(Account{})).Name("getAccount")
+	o.Patch("/accounts/:id", o.Endpoint(h).Param(olive.Patch{}).Returns(Account{}))
+	o.Delete("/accounts/:id", o.Endpoint(h))
+	o.Get("/accounts/:id/details", o.Endpoint(h).Returns(olive.M{})).Name("getAccount")
+	o.Get("/files/**", o.Endpoint(h).Returns([]byte{}))
+	o.Put("/copy/**/to/**", o.Endpoint(h))
+	o.Get("/types/:type/:func/:ctx", o.Endpoint(h).Returns(map[string]int{}))
+	o.Get("/a-b", o.Endpoint(h))
+	o.Get("/a/b", o.Endpoint(h))
+	o.Get("/stats", o.Endpoint(h).Returns(struct {
+		Count int `json:"count"`
+	}{}))
+	o.Any("/any", o.Endpoint(h))
+	return o
+}
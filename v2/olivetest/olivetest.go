@@ -0,0 +1,319 @@
+// Package olivetest makes in-process requests to olive APIs in tests.
+//
+//	func TestCreateAccount(t *testing.T) {
+//		o := olive.Martini()
+//		o.Post("/accounts", o.Endpoint(createAccount).Param(CreateAccountParam{}))
+//
+//		c := olivetest.New(t, o)
+//		var ac Account
+//		c.Post("/accounts", CreateAccountParam{Name: "alice"}).
+//			Send().
+//			ExpectStatus(http.StatusCreated).
+//			Decode(&ac)
+//
+//		c.Post("/accounts", CreateAccountParam{}).
+//			ContentType("application/xml").
+//			Send().
+//			ExpectError(http.StatusBadRequest, 0).
+//			ExpectLog(log.LvlWarn, "failed to create account")
+//	}
+package olivetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-martini/martini"
+	log "github.com/inconshreveable/log15/v3"
+	"github.com/inconshreveable/olive/v2"
+)
+
+// A Client makes in-process requests to a handler.
+type Client struct {
+	t testing.TB
+	h http.Handler
+
+	// codecs used to encode request bodies and decode response bodies,
+	// defaulting to those of the Olive
+	Encoders []olive.ContentEncoder
+	Decoders map[string]olive.Decoder
+
+	// headers sent with every request
+	Header http.Header
+}
+
+// New returns a Client making requests to the routes of om, encoding and
+// decoding bodies with om's codecs.
+func New(t testing.TB, om *olive.OliveMartini) *Client {
+	return &Client{t: t, h: om, Encoders: om.Encoders, Decoders: om.Decoders, Header: make(http.Header)}
+}
+
+// NewEndpoint returns a Client making requests to a single Endpoint, served at every path.
+func NewEndpoint(t testing.TB, e olive.Endpoint) *Client {
+	m := martini.New()
+	rt := martini.NewRouter()
+	rt.Any("/**", e.Handlers()...)
+	m.Action(rt.Handle)
+	o := olive.New(martini.NewRouter())
+	return &Client{t: t, h: m, Encoders: o.Encoders, Decoders: o.Decoders, Header: make(http.Header)}
+}
+
+// NewHandler returns a Client making requests to any handler, with olive's default codecs.
+func NewHandler(t testing.TB, h http.Handler) *Client {
+	o := olive.New(martini.NewRouter())
+	return &Client{t: t, h: h, Encoders: o.Encoders, Decoders: o.Decoders, Header: make(http.Header)}
+}
+
+// Do returns a request with the method and path.
+func (c *Client) Do(method, path string) *Request {
+	return &Request{c: c, method: method, path: path, header: c.Header.Clone(), query: make(url.Values)}
+}
+
+func (c *Client) Get(path string) *Request    { return c.Do(http.MethodGet, path) }
+func (c *Client) Delete(path string) *Request { return c.Do(http.MethodDelete, path) }
+func (c *Client) Post(path string, body interface{}) *Request {
+	return c.Do(http.MethodPost, path).Body(body)
+}
+func (c *Client) Put(path string, body interface{}) *Request {
+	return c.Do(http.MethodPut, path).Body(body)
+}
+func (c *Client) Patch(path string, body interface{}) *Request {
+	return c.Do(http.MethodPatch, path).Body(body)
+}
+
+// A Request is built by chaining calls and made by Send.
+type Request struct {
+	c       *Client
+	method  string
+	path    string
+	header  http.Header
+	query   url.Values
+	body    interface{}
+	hasBody bool
+}
+
+// Header sets a request header.
+func (r *Request) Header(name, value string) *Request {
+	r.header.Set(name, value)
+	return r
+}
+
+// Accept sets the content type the response should be encoded with.
+func (r *Request) Accept(contentType string) *Request {
+	return r.Header("Accept", contentType)
+}
+
+// ContentType sets the content type the body is encoded with, application/json by default.
+func (r *Request) ContentType(contentType string) *Request {
+	return r.Header("Content-Type", contentType)
+}
+
+// Query adds a query parameter.
+func (r *Request) Query(name, value string) *Request {
+	r.query.Add(name, value)
+	return r
+}
+
+// Body sets the request body. Strings and byte slices are sent as is, other
+// values are encoded with the encoder of the request's Content-Type.
+func (r *Request) Body(v interface{}) *Request {
+	r.body, r.hasBody = v, true
+	return r
+}
+
+// Send makes the request.
+func (r *Request) Send() *Response {
+	t := r.c.t
+	t.Helper()
+	var body io.Reader
+	if r.hasBody {
+		if r.header.Get("Content-Type") == "" {
+			r.header.Set("Content-Type", "application/json")
+		}
+		data, err := r.encodeBody()
+		if err != nil {
+			t.Fatalf("olivetest: failed to encode request body: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	target := r.path
+	if len(r.query) > 0 {
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target += sep + r.query.Encode()
+	}
+	req := httptest.NewRequest(r.method, target, body)
+	req.Header = r.header
+
+	logs := new(logCapture)
+	l := log.New()
+	l.SetHandler(log.FuncHandler(logs.add))
+	req = req.WithContext(olive.WithLogger(req.Context(), l))
+
+	w := httptest.NewRecorder()
+	r.c.h.ServeHTTP(w, req)
+	return &Response{ResponseRecorder: w, t: t, c: r.c, req: req, logs: logs}
+}
+
+func (r *Request) encodeBody() ([]byte, error) {
+	switch b := r.body.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		return []byte(b), nil
+	}
+	ct := strings.TrimSpace(strings.Split(r.header.Get("Content-Type"), ";")[0])
+	for _, enc := range r.c.Encoders {
+		if enc.ContentType == ct {
+			var buf bytes.Buffer
+			err := enc.Encode(&buf, r.body)
+			return buf.Bytes(), err
+		}
+	}
+	return nil, fmt.Errorf("no encoder for %s", ct)
+}
+
+// logCapture records the log lines of a request
+type logCapture struct {
+	mu      sync.Mutex
+	records []log.Record
+}
+
+func (c *logCapture) add(r log.Record) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records = append(c.records, r)
+	return nil
+}
+
+// A Response is the recorded response to a Request.
+type Response struct {
+	*httptest.ResponseRecorder
+	t    testing.TB
+	c    *Client
+	req  *http.Request
+	logs *logCapture
+}
+
+// ExpectStatus checks the status code.
+func (r *Response) ExpectStatus(code int) *Response {
+	r.t.Helper()
+	if r.Code != code {
+		r.t.Errorf("%s %s: status %d, want %d; body: %s", r.req.Method, r.req.URL, r.Code, code, r.Body)
+	}
+	return r
+}
+
+// ExpectHeader checks the value of a response header.
+func (r *Response) ExpectHeader(name, value string) *Response {
+	r.t.Helper()
+	if got := r.Header().Get(name); got != value {
+		r.t.Errorf("%s %s: header %s is %q, want %q", r.req.Method, r.req.URL, name, got, value)
+	}
+	return r
+}
+
+// Decode decodes the body into v with the decoder of the response's Content-Type.
+func (r *Response) Decode(v interface{}) *Response {
+	r.t.Helper()
+	ct := strings.TrimSpace(strings.Split(r.Header().Get("Content-Type"), ";")[0])
+	dec, ok := r.c.Decoders[ct]
+	if !ok {
+		r.t.Fatalf("%s %s: no decoder for response Content-Type %q", r.req.Method, r.req.URL, ct)
+	}
+	if err := dec.Decode(bytes.NewReader(r.Body.Bytes()), v); err != nil {
+		r.t.Fatalf("%s %s: failed to decode response: %v; body: %s", r.req.Method, r.req.URL, err, r.Body)
+	}
+	return r
+}
+
+// ExpectBody decodes the body into a new value of want's type and checks that
+// it's deeply equal to want.
+func (r *Response) ExpectBody(want interface{}) *Response {
+	r.t.Helper()
+	t := reflect.TypeOf(want)
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	got := reflect.New(t)
+	r.Decode(got.Interface())
+	if !ptr {
+		got = got.Elem()
+	}
+	if !reflect.DeepEqual(got.Interface(), want) {
+		r.t.Errorf("%s %s: body is %#v, want %#v", r.req.Method, r.req.URL, got.Interface(), want)
+	}
+	return r
+}
+
+// Error decodes the body as an *olive.Error.
+func (r *Response) Error() *olive.Error {
+	r.t.Helper()
+	var apiErr olive.Error
+	r.Decode(&apiErr)
+	return &apiErr
+}
+
+// ExpectError checks that the body is an *olive.Error with the status code and
+// error code. An error code of zero matches any.
+func (r *Response) ExpectError(status, errorCode int) *Response {
+	r.t.Helper()
+	r.ExpectStatus(status)
+	apiErr := r.Error()
+	if apiErr.StatusCode != status {
+		r.t.Errorf("%s %s: error status_code is %d, want %d", r.req.Method, r.req.URL, apiErr.StatusCode, status)
+	}
+	if errorCode != 0 && apiErr.ErrorCode != errorCode {
+		r.t.Errorf("%s %s: error_code is %d, want %d", r.req.Method, r.req.URL, apiErr.ErrorCode, errorCode)
+	}
+	return r
+}
+
+// ExpectErrorDetail checks that the body is an *olive.Error with the detail.
+// Values are compared by their string formatting since decoding loses their types.
+func (r *Response) ExpectErrorDetail(key string, value interface{}) *Response {
+	r.t.Helper()
+	details := r.Error().Details
+	got, ok := details[key]
+	if !ok {
+		r.t.Errorf("%s %s: error has no detail %q; details: %v", r.req.Method, r.req.URL, key, details)
+	} else if fmt.Sprint(got) != fmt.Sprint(value) {
+		r.t.Errorf("%s %s: error detail %q is %v, want %v", r.req.Method, r.req.URL, key, got, value)
+	}
+	return r
+}
+
+// Logs returns the log lines the request's logger has emitted so far.
+func (r *Response) Logs() []log.Record {
+	r.logs.mu.Lock()
+	defer r.logs.mu.Unlock()
+	return append([]log.Record(nil), r.logs.records...)
+}
+
+// ExpectLog checks that a log line with the level and message was emitted.
+func (r *Response) ExpectLog(lvl log.Lvl, msg string) *Response {
+	r.t.Helper()
+	logs := r.Logs()
+	for _, rec := range logs {
+		if rec.Lvl == lvl && rec.Msg == msg {
+			return r
+		}
+	}
+	lines := make([]string, len(logs))
+	for i, rec := range logs {
+		lines[i] = fmt.Sprintf("%s %s", rec.Lvl, rec.Msg)
+	}
+	r.t.Errorf("%s %s: no %s log line %q; logged: %s", r.req.Method, r.req.URL, lvl, msg, strings.Join(lines, ", "))
+	return r
+}
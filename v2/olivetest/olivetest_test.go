@@ -0,0 +1,149 @@
+package olivetest_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	log "github.com/inconshreveable/log15/v3"
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+type Account struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type CreateAccountParam struct {
+	Name string `json:"name"`
+}
+
+var ErrNameRequired = &olive.ErrorDef{StatusCode: http.StatusBadRequest, ErrorCode: 1001, Message: "name required"}
+
+func createAccount(r olive.Response, p *CreateAccountParam, l log.Logger) {
+	if p.Name == "" {
+		l.Warn("failed to create account")
+		r.Abort(ErrNameRequired.New(olive.M{"field": "name"}))
+	}
+	r.Created("/accounts/1", &Account{ID: "1", Name: p.Name})
+}
+
+func newOlive() *olive.OliveMartini {
+	o := olive.Martini()
+	o.Post("/accounts", o.Endpoint(createAccount).Param(CreateAccountParam{}))
+	o.Get("/echo", o.Endpoint(func(r olive.Response, req *http.Request) {
+		r.Encode(map[string]string{"q": req.URL.Query().Get("q"), "token": req.Header.Get("X-Token")})
+	}))
+	return o
+}
+
+func TestClient(t *testing.T) {
+	c := olivetest.New(t, newOlive())
+
+	var ac Account
+	c.Post("/accounts", CreateAccountParam{Name: "alice"}).Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Location", "/accounts/1").
+		Decode(&ac)
+	if ac != (Account{ID: "1", Name: "alice"}) {
+		t.Errorf("decoded %+v", ac)
+	}
+
+	c.Post("/accounts", CreateAccountParam{}).Send().
+		ExpectError(http.StatusBadRequest, 1001).
+		ExpectErrorDetail("field", "name").
+		ExpectLog(log.LvlWarn, "failed to create account")
+
+	c.Header.Set("X-Token", "t0")
+	c.Get("/echo").Query("q", "a b").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(map[string]string{"q": "a b", "token": "t0"})
+	c.Get("/echo?q=x").Header("X-Token", "t1").Send().
+		ExpectBody(&map[string]string{"q": "x", "token": "t1"})
+}
+
+func TestClientXML(t *testing.T) {
+	c := olivetest.New(t, newOlive())
+
+	var ac Account
+	c.Post("/accounts", CreateAccountParam{Name: "bob"}).
+		ContentType("application/xml").
+		Accept("application/xml").
+		Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Content-Type", "application/xml").
+		Decode(&ac)
+	if ac.Name != "bob" {
+		t.Errorf("decoded %+v", ac)
+	}
+	c.Post("/accounts", `<CreateAccountParam></CreateAccountParam>`).
+		ContentType("application/xml").
+		Accept("application/xml").
+		Send().
+		ExpectError(http.StatusBadRequest, 1001).
+		ExpectErrorDetail("field", "name")
+}
+
+func TestErrorDetailsRoundTripXML(t *testing.T) {
+	o := olive.Martini()
+	o.Get("/fail", o.Endpoint(func(r olive.Response) {
+		r.Abort(&olive.Error{
+			StatusCode: http.StatusConflict,
+			Message:    "conflict",
+			Details:    olive.M{"id": "a1", "limits": olive.M{"max": 3}, "tags": []string{"x", "y"}},
+		})
+	}))
+	c := olivetest.New(t, o)
+
+	apiErr := c.Get("/fail").Accept("application/xml").Send().
+		ExpectStatus(http.StatusConflict).
+		ExpectHeader("Content-Type", "application/xml").
+		ExpectErrorDetail("id", "a1").
+		Error()
+	if limits, ok := apiErr.Details["limits"].(olive.M); !ok || limits["max"] != "3" {
+		t.Errorf("nested details %#v, want a map with max 3", apiErr.Details["limits"])
+	}
+	if tags, ok := apiErr.Details["tags"].([]interface{}); !ok || len(tags) != 2 {
+		t.Errorf("repeated details %#v, want 2 elements", apiErr.Details["tags"])
+	}
+}
+
+func TestNewEndpoint(t *testing.T) {
+	o := olive.Martini()
+	c := olivetest.NewEndpoint(t, o.Endpoint(createAccount).Param(CreateAccountParam{}))
+
+	c.Post("/anywhere", CreateAccountParam{Name: "carol"}).Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectBody(Account{ID: "1", Name: "carol"})
+}
+
+// failures records the failures reported to a testing.TB
+type failures struct {
+	testing.TB
+	errs []string
+}
+
+func (f *failures) Helper() {}
+
+func (f *failures) Errorf(format string, args ...interface{}) {
+	f.errs = append(f.errs, fmt.Sprintf(format, args...))
+}
+
+func TestExpectationsReportFailures(t *testing.T) {
+	f := &failures{TB: t}
+	c := olivetest.New(f, newOlive())
+
+	c.Post("/accounts", CreateAccountParam{}).Send().
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Location", "/accounts/1").
+		ExpectError(http.StatusBadRequest, 1002).
+		ExpectErrorDetail("field", "email").
+		ExpectErrorDetail("missing", "x").
+		ExpectLog(log.LvlError, "failed to create account")
+	c.Get("/echo").Send().ExpectBody(map[string]string{"q": "y"})
+
+	if len(f.errs) != 7 {
+		t.Errorf("reported %d failures, want 7:\n%v", len(f.errs), f.errs)
+	}
+}
@@ -0,0 +1,450 @@
+package olive
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	log "github.com/inconshreveable/log15/v3"
+)
+
+// OperationStatus is the state of an asynchronous Operation.
+type OperationStatus string
+
+const (
+	OperationPending   OperationStatus = "pending"
+	OperationRunning   OperationStatus = "running"
+	OperationSucceeded OperationStatus = "succeeded"
+	OperationFailed    OperationStatus = "failed"
+	OperationCanceled  OperationStatus = "canceled"
+)
+
+// Done reports whether the operation has finished.
+func (s OperationStatus) Done() bool {
+	return s == OperationSucceeded || s == OperationFailed || s == OperationCanceled
+}
+
+// An Operation is the status resource of a long-running operation.
+type Operation struct {
+	XMLName  xml.Name        `json:"-" xml:"Operation"`
+	ID       string          `json:"id" xml:"ID"`
+	Status   OperationStatus `json:"status" xml:"Status"`
+	Progress float64         `json:"progress" xml:"Progress"` // from 0 to 1
+	Message  string          `json:"message,omitempty" xml:"Message,omitempty"`
+	Result   interface{}     `json:"result,omitempty" xml:"Result,omitempty"`
+	Error    *Error          `json:"error,omitempty" xml:"Error,omitempty"`
+	Created  time.Time       `json:"created" xml:"Created"`
+	Updated  time.Time       `json:"updated" xml:"Updated"`
+
+	// subject of the principal that started the operation, the only one allowed to
+	// read or cancel it. An OperationStore must persist it.
+	Owner string `json:"-" xml:"-"`
+}
+
+// MarshalXML encodes the operation, encoding a Result that encoding/xml can't
+// encode, like a map, the way its JSON encoding would be decoded into an M.
+func (op Operation) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type operation Operation
+	v := struct {
+		operation
+		Result interface{} `xml:"Result,omitempty"`
+	}{operation(op), xmlResult(op.Result)}
+	return e.EncodeElement(v, start)
+}
+
+// xmlResult returns the value encoding the Result v of an operation as XML
+func xmlResult(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if err := xml.NewEncoder(io.Discard).EncodeElement(v, xml.StartElement{Name: xml.Name{Local: "Result"}}); err == nil {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil
+	}
+	return xmlGeneric(generic)
+}
+
+// xmlGeneric converts the objects of a decoded JSON value into Ms
+func xmlGeneric(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(M, len(v))
+		for k, x := range v {
+			m[k] = xmlGeneric(x)
+		}
+		return m
+	case []interface{}:
+		for i, x := range v {
+			v[i] = xmlGeneric(x)
+		}
+	}
+	return v
+}
+
+// An OperationFunc performs a long-running operation. It should return when ctx
+// is done and may report its progress, from 0 to 1, with a message.
+type OperationFunc func(ctx context.Context, report func(progress float64, message string)) (interface{}, error)
+
+// An OperationStore persists the state of Operations.
+type OperationStore interface {
+	Save(op *Operation) error
+
+	// Load returns the operation with the id, or nil if there is none.
+	Load(id string) (*Operation, error)
+
+	Delete(id string) error
+}
+
+// Operations runs long-running operations in a bounded pool of workers and
+// serves their status resources. Register the status endpoints with
+// Olive.Operations and start operations from handlers with Start:
+//
+//	exports := &olive.Operations{Workers: 2}
+//	o.Operations("/operations", exports)
+//	o.Post("/exports", o.Endpoint(func(r olive.Response, p *ExportParam) {
+//		exports.Start(r, func(ctx context.Context, report func(float64, string)) (interface{}, error) {
+//			return export.Run(ctx, p, report)
+//		})
+//	}).Param(ExportParam{}))
+//
+// An operation can only be read and canceled by the principal that started it,
+// identified by its Subject. Cancelling an operation with a DELETE request only
+// stops it if it's running in this process.
+type Operations struct {
+	Workers int            // number of operations run concurrently, defaults to 4
+	Queue   int            // number of operations waiting for a worker, defaults to 100
+	TTL     time.Duration  // how long finished operations are kept, defaults to an hour
+	Store   OperationStore // defaults to an in-memory store
+
+	once    sync.Once
+	path    string
+	queue   chan *operationJob
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+
+	stopping bool
+	wg       sync.WaitGroup // queued and running operations
+}
+
+type operationJob struct {
+	ctx context.Context
+	op  *Operation
+	fn  OperationFunc
+	e   *errEncoder
+	l   log.Logger
+}
+
+func (ops *Operations) init() {
+	ops.once.Do(func() {
+		if ops.Workers == 0 {
+			ops.Workers = 4
+		}
+		if ops.Queue == 0 {
+			ops.Queue = 100
+		}
+		if ops.TTL == 0 {
+			ops.TTL = time.Hour
+		}
+		if ops.Store == nil {
+			ops.Store = NewMemoryOperationStore()
+		}
+		ops.queue = make(chan *operationJob, ops.Queue)
+		ops.running = make(map[string]context.CancelFunc)
+		for i := 0; i < ops.Workers; i++ {
+			go ops.work()
+		}
+	})
+}
+
+// Operations registers GET and DELETE endpoints at pattern/:id that serve and
+// cancel the operations started by ops.
+func (o *Olive) Operations(pattern string, ops *Operations) {
+	ops.init()
+	ops.path = o.prefix + pattern
+	o.Get(pattern+"/:id", o.Endpoint(ops.get).Returns(Operation{}))
+	o.Delete(pattern+"/:id", o.Endpoint(ops.cancel))
+}
+
+// Start queues an operation performed by fn and responds with 202 Accepted and
+// the Location of its status resource. If the queue is full or ops is shutting
+// down, it aborts with 503 Service Unavailable. It panics if ops hasn't been
+// registered with Olive.Operations, since there would be no status resource.
+func (ops *Operations) Start(r Response, fn OperationFunc) {
+	ops.init()
+	if ops.path == "" {
+		panic("olive: Operations must be registered with Olive.Operations before starting operations")
+	}
+	now := time.Now()
+	op := &Operation{ID: operationID(), Status: OperationPending, Created: now, Updated: now, Owner: subject(r)}
+	job := &operationJob{op: op, fn: fn, l: r.New("op", op.ID)}
+	if rr, ok := r.(*response); ok {
+		job.e = rr.errEncoder
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job.ctx = ctx
+
+	if err := ops.Store.Save(op); err != nil {
+		cancel()
+		r.Abort(err)
+	}
+	ops.mu.Lock()
+	ops.running[op.ID] = cancel
+	queued := false
+	if !ops.stopping {
+		ops.wg.Add(1)
+		select {
+		case ops.queue <- job:
+			queued = true
+		default:
+			ops.wg.Done()
+		}
+	}
+	ops.mu.Unlock()
+	if !queued {
+		ops.forget(op.ID)
+		ops.Store.Delete(op.ID)
+		r.Header().Set("Retry-After", "1")
+		r.Abort(&Error{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "too many pending operations",
+		})
+	}
+	r.Header().Set("Location", ops.path+"/"+op.ID)
+	r.WriteHeader(http.StatusAccepted)
+	r.Encode(op)
+}
+
+// operationID returns an unguessable id because operations are only protected
+// by the status endpoints' authentication
+func operationID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (ops *Operations) work() {
+	for job := range ops.queue {
+		ops.run(job)
+		ops.wg.Done()
+	}
+}
+
+// Shutdown stops accepting operations and waits for the queued and running ones
+// to finish. When ctx is done, it cancels them and returns ctx's error. It can be
+// registered as a shutdown hook with OliveMartini.OnShutdown.
+func (ops *Operations) Shutdown(ctx context.Context) error {
+	ops.init()
+	ops.mu.Lock()
+	ops.stopping = true
+	ops.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		ops.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		ops.mu.Lock()
+		for _, cancel := range ops.running {
+			cancel()
+		}
+		ops.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (ops *Operations) run(job *operationJob) {
+	id := job.op.ID
+	defer time.AfterFunc(ops.TTL, func() { ops.Store.Delete(id) })
+	defer ops.forget(id)
+	if job.ctx.Err() != nil {
+		// canceled while queued
+		return
+	}
+	ops.update(job.l, id, func(op *Operation) {
+		if op.Status == OperationPending {
+			op.Status = OperationRunning
+		}
+	})
+	report := func(progress float64, message string) {
+		ops.update(job.l, id, func(op *Operation) {
+			op.Progress, op.Message = progress, message
+		})
+	}
+	result, err := func() (result interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				job.l.Crit("operation panicked", "panic", p)
+				err = &Error{StatusCode: http.StatusInternalServerError, Message: "operation failed"}
+			}
+		}()
+		return job.fn(job.ctx, report)
+	}()
+	ops.update(job.l, id, func(op *Operation) {
+		switch {
+		case op.Status == OperationCanceled:
+		case err != nil:
+			op.Status = OperationFailed
+			op.Error = job.operationError(err)
+		default:
+			op.Status = OperationSucceeded
+			op.Progress = 1
+			op.Result = result
+		}
+	})
+}
+
+// operationError translates the error an operation failed with like Abort does
+func (job *operationJob) operationError(err error) *Error {
+	var mappers []ErrorMapper
+	debug := false
+	if job.e != nil {
+		mappers, debug = job.e.mappers, job.e.debug
+	}
+	apiErr, ok := translateError(err, mappers)
+	if !ok {
+		apiErr = internalServerError(err)
+		if !debug {
+			apiErr.Details = nil
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(apiErr.StatusCode)
+	}
+	job.l.Warn("operation failed", "err", err)
+	return apiErr
+}
+
+// update modifies the stored operation, serializing updates made by this process
+func (ops *Operations) update(l log.Logger, id string, fn func(*Operation)) *Operation {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	op, err := ops.Store.Load(id)
+	if err != nil || op == nil {
+		l.Error("failed to load operation", "err", err)
+		return nil
+	}
+	fn(op)
+	op.Updated = time.Now()
+	if err := ops.Store.Save(op); err != nil {
+		l.Error("failed to save operation", "err", err)
+	}
+	return op
+}
+
+func (ops *Operations) forget(id string) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	if cancel, ok := ops.running[id]; ok {
+		cancel()
+		delete(ops.running, id)
+	}
+}
+
+// load returns the operation with the id if it was started by the principal making the request
+func (ops *Operations) load(r Response, id string) *Operation {
+	op, err := ops.Store.Load(id)
+	if err != nil {
+		r.Abort(err)
+	}
+	// other principals' operations are reported missing so as not to reveal them
+	if op == nil || op.Owner != subject(r) {
+		r.Abort(&Error{
+			StatusCode: http.StatusNotFound,
+			Message:    "operation not found",
+			Details:    M{"id": id},
+		})
+	}
+	return op
+}
+
+// get serves the status resource of an operation
+func (ops *Operations) get(r Response, params martini.Params) {
+	op := ops.load(r, params["id"])
+	if !op.Status.Done() {
+		r.Header().Set("Retry-After", "1")
+	}
+	r.Encode(op)
+}
+
+// cancel cancels an unfinished operation or deletes a finished one
+func (ops *Operations) cancel(r Response, params martini.Params) {
+	op := ops.load(r, params["id"])
+	if op.Status.Done() {
+		if err := ops.Store.Delete(op.ID); err != nil {
+			r.Abort(err)
+		}
+		r.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if canceled := ops.update(r, op.ID, func(op *Operation) {
+		if !op.Status.Done() {
+			op.Status = OperationCanceled
+		}
+	}); canceled != nil {
+		op = canceled
+	}
+	ops.forget(op.ID)
+	r.Info("canceled operation", "op", op.ID)
+	r.Encode(op)
+}
+
+// subject returns the subject of the principal making the request, or the empty
+// string if the request isn't authenticated
+func subject(r Response) string {
+	if p := r.Principal(); p != nil {
+		return p.Subject()
+	}
+	return ""
+}
+
+// MemoryOperationStore is an in-memory OperationStore.
+type MemoryOperationStore struct {
+	mu  sync.Mutex
+	ops map[string]Operation
+}
+
+// NewMemoryOperationStore returns an empty MemoryOperationStore.
+func NewMemoryOperationStore() *MemoryOperationStore {
+	return &MemoryOperationStore{ops: make(map[string]Operation)}
+}
+
+func (s *MemoryOperationStore) Save(op *Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops[op.ID] = *op
+	return nil
+}
+
+func (s *MemoryOperationStore) Load(id string) (*Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.ops[id]
+	if !ok {
+		return nil, nil
+	}
+	return &op, nil
+}
+
+func (s *MemoryOperationStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ops, id)
+	return nil
+}
@@ -0,0 +1,179 @@
+package olive_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15/v3"
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+// operationsClient serves the operations of ops at /ops, started with POST /start?op=name
+// by fns[name]. Requests are authenticated with a bearer token naming the user.
+func operationsClient(t *testing.T, ops *olive.Operations, fns map[string]olive.OperationFunc) *olivetest.Client {
+	o := olive.Martini()
+	o.Authenticators = []olive.Authenticator{&olive.BearerAuth{
+		Validate: func(token string) (olive.Principal, error) {
+			return &olive.User{Name: token}, nil
+		},
+	}}
+	o.Operations("/ops", ops)
+	o.Post("/start", o.Endpoint(func(r olive.Response, req *http.Request) {
+		ops.Start(r, fns[req.URL.Query().Get("op")])
+	}))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ops.Shutdown(ctx)
+	})
+	return olivetest.New(t, o)
+}
+
+// startOperation starts the operation as alice and returns the path of its status resource
+func startOperation(c *olivetest.Client, name string) string {
+	var op olive.Operation
+	resp := c.Post("/start", nil).Query("op", name).Header("Authorization", "Bearer alice").Send().
+		ExpectStatus(http.StatusAccepted).
+		Decode(&op)
+	if op.Status != olive.OperationPending {
+		resp.ExpectBody("pending operation")
+	}
+	if loc := resp.Header().Get("Location"); loc != "/ops/"+op.ID {
+		resp.ExpectHeader("Location", "/ops/"+op.ID)
+	}
+	return "/ops/" + op.ID
+}
+
+// pollOperation polls the status resource until the operation is done
+func pollOperation(t *testing.T, c *olivetest.Client, path string) olive.Operation {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		var op olive.Operation
+		resp := c.Get(path).Header("Authorization", "Bearer alice").Send().
+			ExpectStatus(http.StatusOK).
+			Decode(&op)
+		if op.Status.Done() {
+			resp.ExpectHeader("Retry-After", "")
+			return op
+		}
+		resp.ExpectHeader("Retry-After", "1")
+	}
+	t.Fatalf("operation %s didn't finish", path)
+	return olive.Operation{}
+}
+
+func TestOperationLifecycle(t *testing.T) {
+	release := make(chan struct{})
+	ops := &olive.Operations{Workers: 1}
+	c := operationsClient(t, ops, map[string]olive.OperationFunc{
+		"export": func(ctx context.Context, report func(float64, string)) (interface{}, error) {
+			report(0.5, "halfway")
+			<-release
+			return map[string]interface{}{"rows": 3, "files": []interface{}{map[string]interface{}{"name": "a.csv"}}}, nil
+		},
+		"fail": func(ctx context.Context, report func(float64, string)) (interface{}, error) {
+			return nil, &olive.Error{StatusCode: http.StatusConflict, Message: "export conflicts"}
+		},
+		"crash": func(ctx context.Context, report func(float64, string)) (interface{}, error) {
+			panic("boom")
+		},
+		"wait": func(ctx context.Context, report func(float64, string)) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+
+	path := startOperation(c, "export")
+	var running olive.Operation
+	for running.Message != "halfway" {
+		c.Get(path).Header("Authorization", "Bearer alice").Send().
+			ExpectStatus(http.StatusOK).
+			ExpectHeader("Retry-After", "1").
+			Decode(&running)
+	}
+	if running.Status != olive.OperationRunning || running.Progress != 0.5 {
+		t.Errorf("running operation is %+v", running)
+	}
+	close(release)
+	op := pollOperation(t, c, path)
+	if op.Status != olive.OperationSucceeded || op.Progress != 1 || op.Result.(map[string]interface{})["rows"] != float64(3) {
+		t.Errorf("succeeded operation is %+v", op)
+	}
+	body := c.Get(path).Header("Authorization", "Bearer alice").Accept("application/xml").Send().
+		ExpectStatus(http.StatusOK).
+		Body.String()
+	var xmlOp struct {
+		Status string `xml:"Status"`
+		Rows   int    `xml:"Result>rows"`
+		File   string `xml:"Result>files>name"`
+	}
+	if err := xml.Unmarshal([]byte(body), &xmlOp); err != nil || xmlOp.Status != "succeeded" || xmlOp.Rows != 3 || xmlOp.File != "a.csv" {
+		t.Errorf("XML operation is %+v (%v): %s", xmlOp, err, body)
+	}
+
+	op = pollOperation(t, c, startOperation(c, "fail"))
+	if op.Status != olive.OperationFailed || op.Error == nil || op.Error.StatusCode != http.StatusConflict || op.Error.Message != "export conflicts" {
+		t.Errorf("failed operation is %+v", op)
+	}
+	op = pollOperation(t, c, startOperation(c, "crash"))
+	if op.Status != olive.OperationFailed || op.Error == nil || op.Error.StatusCode != http.StatusInternalServerError {
+		t.Errorf("crashed operation is %+v", op)
+	}
+
+	path = startOperation(c, "wait")
+	c.Delete(path).Header("Authorization", "Bearer alice").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectLog(log.LvlInfo, "canceled operation").
+		Decode(&op)
+	if op.Status != olive.OperationCanceled {
+		t.Errorf("canceled operation is %+v", op)
+	}
+	if op = pollOperation(t, c, path); op.Status != olive.OperationCanceled {
+		t.Errorf("canceled operation finished as %+v", op)
+	}
+	c.Delete(path).Header("Authorization", "Bearer alice").Send().
+		ExpectStatus(http.StatusNoContent)
+	c.Get(path).Header("Authorization", "Bearer alice").Send().
+		ExpectError(http.StatusNotFound, 0)
+}
+
+func TestOperationsAreBoundToTheirOwner(t *testing.T) {
+	ops := &olive.Operations{}
+	c := operationsClient(t, ops, map[string]olive.OperationFunc{
+		"wait": func(ctx context.Context, report func(float64, string)) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+
+	path := startOperation(c, "wait")
+	c.Get(path).Header("Authorization", "Bearer bob").Send().
+		ExpectError(http.StatusNotFound, 0)
+	c.Delete(path).Header("Authorization", "Bearer bob").Send().
+		ExpectError(http.StatusNotFound, 0)
+	c.Get(path).Header("Authorization", "Bearer alice").Send().
+		ExpectStatus(http.StatusOK)
+	c.Delete(path).Header("Authorization", "Bearer alice").Send().
+		ExpectStatus(http.StatusOK)
+}
+
+func TestStartUnregisteredOperations(t *testing.T) {
+	ops := &olive.Operations{}
+	o := olive.Martini()
+	o.Post("/start", o.Endpoint(func(r olive.Response) {
+		ops.Start(r, func(ctx context.Context, report func(float64, string)) (interface{}, error) {
+			return nil, errors.New("unreachable")
+		})
+	}))
+	c := olivetest.New(t, o)
+
+	c.Post("/start", nil).Send().
+		ExpectError(http.StatusInternalServerError, 0).
+		ExpectHeader("Location", "").
+		ExpectLog(log.LvlCrit, "handler crashed")
+}
@@ -0,0 +1,266 @@
+package olive
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-martini/martini"
+)
+
+// PageOptions configures the pagination of a list Endpoint.
+type PageOptions struct {
+	DefaultLimit int // limit when the client doesn't request one, defaults to 20
+	MaxLimit     int // largest limit a client may request, defaults to 100
+
+	// key used to sign cursors so that clients can't forge or modify them,
+	// if empty cursors are only encoded
+	CursorSecret []byte
+}
+
+// A Page is the page of results requested by a client with the page, limit and
+// cursor query parameters. Endpoints configured with Paginate are injected with a
+// *Page; requests with invalid parameters are rejected with a 400 *Error.
+//
+// Offset-based pagination uses the page number:
+//
+//	func listAccounts(r olive.Response, p *olive.Page) {
+//		acs, total := account.List(p.Offset(), p.Limit)
+//		p.SetLinks(total)
+//		r.Encode(p.Envelope(acs, total))
+//	}
+//
+// Cursor-based pagination passes an opaque cursor between requests:
+//
+//	func listEvents(r olive.Response, p *olive.Page) {
+//		var after int64
+//		if _, err := p.DecodeCursor(&after); err != nil {
+//			r.Abort(err)
+//		}
+//		events := event.ListAfter(after, p.Limit)
+//		var next interface{}
+//		if len(events) == p.Limit {
+//			next = events[len(events)-1].ID
+//		}
+//		p.SetCursorLinks(next)
+//		r.Encode(events)
+//	}
+type Page struct {
+	Number int    // 1-based page number
+	Limit  int    // maximum number of results on the page
+	Cursor string // opaque cursor, empty for the first page
+
+	opts   PageOptions
+	url    *url.URL
+	header http.Header
+}
+
+// Offset returns the number of results preceding the page.
+func (p *Page) Offset() int {
+	return (p.Number - 1) * p.Limit
+}
+
+// EncodeCursor returns an opaque cursor encoding v as JSON, signed with the
+// CursorSecret if there is one.
+func (p *Page) EncodeCursor(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	cursor := base64.RawURLEncoding.EncodeToString(data)
+	if len(p.opts.CursorSecret) > 0 {
+		cursor += "." + base64.RawURLEncoding.EncodeToString(p.sign(cursor))
+	}
+	return cursor, nil
+}
+
+// DecodeCursor decodes the page's cursor into v. It returns false if the
+// request has no cursor.
+func (p *Page) DecodeCursor(v interface{}) (bool, error) {
+	if p.Cursor == "" {
+		return false, nil
+	}
+	payload, err := p.verify(p.Cursor)
+	if err == nil {
+		err = json.Unmarshal(payload, v)
+	}
+	if err != nil {
+		return true, invalidPageParam("cursor", p.Cursor, err)
+	}
+	return true, nil
+}
+
+func (p *Page) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, p.opts.CursorSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verify checks a cursor's signature and returns its decoded payload
+func (p *Page) verify(cursor string) ([]byte, error) {
+	payload := cursor
+	if len(p.opts.CursorSecret) > 0 {
+		var sig string
+		payload, sig = split(cursor, ".")
+		got, err := base64.RawURLEncoding.DecodeString(sig)
+		if err != nil || !hmac.Equal(got, p.sign(payload)) {
+			return nil, errors.New("invalid cursor signature")
+		}
+	}
+	return base64.RawURLEncoding.DecodeString(payload)
+}
+
+// link returns the URL of the current request with the query parameters replaced
+func (p *Page) link(params map[string]string) string {
+	u := *p.url
+	q := u.Query()
+	for k, v := range params {
+		if v == "" {
+			q.Del(k)
+		} else {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+func (p *Page) addLink(rel, href string) {
+	p.header.Add("Link", fmt.Sprintf("<%s>; rel=%q", href, rel))
+}
+
+// SetLinks sets Link headers (RFC 8288) to the first, previous, next and last
+// pages given the total number of results. If the total is negative, it is
+// unknown and the last link is omitted.
+func (p *Page) SetLinks(total int) {
+	limit := strconv.Itoa(p.Limit)
+	page := func(n int) string {
+		return p.link(map[string]string{"page": strconv.Itoa(n), "limit": limit, "cursor": ""})
+	}
+	p.addLink("first", page(1))
+	if p.Number > 1 {
+		p.addLink("prev", page(p.Number-1))
+	}
+	if total < 0 {
+		p.addLink("next", page(p.Number+1))
+		return
+	}
+	last := (total + p.Limit - 1) / p.Limit
+	if last < 1 {
+		last = 1
+	}
+	if p.Number < last {
+		p.addLink("next", page(p.Number+1))
+	}
+	p.addLink("last", page(last))
+}
+
+// SetCursorLinks sets Link headers to the first page and, if next is not nil,
+// to the page after the cursor encoding next.
+func (p *Page) SetCursorLinks(next interface{}) error {
+	limit := strconv.Itoa(p.Limit)
+	p.addLink("first", p.link(map[string]string{"limit": limit, "cursor": "", "page": ""}))
+	if next == nil {
+		return nil
+	}
+	cursor, err := p.EncodeCursor(next)
+	if err != nil {
+		return err
+	}
+	p.addLink("next", p.link(map[string]string{"limit": limit, "cursor": cursor, "page": ""}))
+	return nil
+}
+
+// A PageEnvelope wraps a page of results with pagination metadata.
+type PageEnvelope struct {
+	XMLName xml.Name    `json:"-" xml:"Page"`
+	Items   interface{} `json:"items" xml:"Items"`
+	Page    int         `json:"page,omitempty" xml:"Number,omitempty"`
+	Limit   int         `json:"limit" xml:"Limit"`
+	Total   *int        `json:"total,omitempty" xml:"Total,omitempty"`
+	Next    string      `json:"next,omitempty" xml:"Next,omitempty"`
+	Prev    string      `json:"prev,omitempty" xml:"Prev,omitempty"`
+}
+
+// Envelope wraps the items of an offset-based page. If the total is negative,
+// it is unknown and omitted.
+func (p *Page) Envelope(items interface{}, total int) *PageEnvelope {
+	env := &PageEnvelope{Items: items, Page: p.Number, Limit: p.Limit}
+	limit := strconv.Itoa(p.Limit)
+	if total >= 0 {
+		env.Total = &total
+	}
+	if total < 0 || p.Number*p.Limit < total {
+		env.Next = p.link(map[string]string{"page": strconv.Itoa(p.Number + 1), "limit": limit})
+	}
+	if p.Number > 1 {
+		env.Prev = p.link(map[string]string{"page": strconv.Itoa(p.Number - 1), "limit": limit})
+	}
+	return env
+}
+
+func invalidPageParam(name, value string, err error) *Error {
+	return &Error{
+		StatusCode: http.StatusBadRequest,
+		Message:    "invalid pagination parameter",
+		Details:    M{"param": name, "value": value, "err": err.Error()},
+	}
+}
+
+// pageParams are the query parameters bound to a *Page
+var pageParams = []string{"page", "limit", "cursor"}
+
+// paginationMiddleware binds and validates the pagination query parameters
+func paginationMiddleware(opts *PageOptions) martini.Handler {
+	return func(req *http.Request, w http.ResponseWriter, c martini.Context, e *errEncoder) {
+		if opts == nil {
+			return
+		}
+		o := *opts
+		if o.DefaultLimit == 0 {
+			o.DefaultLimit = 20
+		}
+		if o.MaxLimit == 0 {
+			o.MaxLimit = 100
+		}
+		q := req.URL.Query()
+		p := &Page{Number: 1, Limit: o.DefaultLimit, Cursor: q.Get("cursor"), opts: o, url: req.URL, header: w.Header()}
+		if v := q.Get("page"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err == nil && n < 1 {
+				err = errors.New("page must be at least 1")
+			}
+			if err != nil {
+				e.Abort(invalidPageParam("page", v, err))
+			}
+			p.Number = n
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err == nil && (n < 1 || n > o.MaxLimit) {
+				err = fmt.Errorf("limit must be between 1 and %d", o.MaxLimit)
+			}
+			if err != nil {
+				e.Abort(invalidPageParam("limit", v, err))
+			}
+			p.Limit = n
+		}
+		if p.Cursor != "" {
+			if strings.TrimSpace(q.Get("page")) != "" {
+				e.Abort(invalidPageParam("cursor", p.Cursor, errors.New("cursor and page are mutually exclusive")))
+			}
+			if _, err := p.verify(p.Cursor); err != nil {
+				e.Abort(invalidPageParam("cursor", p.Cursor, err))
+			}
+		}
+		c.Map(p)
+	}
+}
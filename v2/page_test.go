@@ -0,0 +1,35 @@
+package olive_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+type listParam struct {
+	Prefix string `param:"prefix"`
+}
+
+func TestPaginateWithParam(t *testing.T) {
+	o := olive.Martini()
+	o.Get("/items", o.Endpoint(func(r olive.Response, p *listParam, page *olive.Page) {
+		r.Encode(map[string]interface{}{"prefix": p.Prefix, "offset": page.Offset(), "limit": page.Limit})
+	}).Param(listParam{}).Paginate(olive.PageOptions{}))
+	c := olivetest.New(t, o)
+
+	c.Get("/items").Query("prefix", "a").Query("page", "3").Query("limit", "10").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(map[string]interface{}{"prefix": "a", "offset": float64(20), "limit": float64(10)})
+	c.Get("/items").Query("page", "2").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(map[string]interface{}{"prefix": "", "offset": float64(20), "limit": float64(20)})
+
+	// unknown parameters are still rejected
+	c.Get("/items").Query("page", "2").Query("nope", "1").Send().
+		ExpectError(http.StatusBadRequest, 0)
+	c.Get("/items").Query("limit", "1000").Send().
+		ExpectError(http.StatusBadRequest, 0).
+		ExpectErrorDetail("param", "limit")
+}
@@ -0,0 +1,513 @@
+package olive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A Patch is a set of changes to a resource decoded from the body of a PATCH
+// request. Endpoints accept patches by using a Patch as their Param:
+//
+//	o.Patch("/accounts/:id", o.Endpoint(patchAccount).Param(olive.Patch{}))
+//
+//	func patchAccount(r olive.Response, p *olive.Patch, params martini.Params) {
+//		ac := account.Get(params["id"])
+//		if err := p.Apply(ac); err != nil {
+//			r.Abort(err)
+//		}
+//		account.Save(ac)
+//		r.Encode(ac)
+//	}
+//
+// A body of Content-Type application/merge-patch+json (or application/json) is
+// a JSON Merge Patch (RFC 7396) and a body of Content-Type application/json-patch+json
+// is a JSON Patch (RFC 6902). Endpoints whose Param is a Patch only decode these
+// content types, and list them in the Accept-Patch header of OPTIONS responses.
+type Patch struct {
+	merge json.RawMessage
+	ops   []PatchOp
+}
+
+// A PatchOp is an operation of a JSON Patch.
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// UnmarshalJSON decodes a JSON Merge Patch.
+func (p *Patch) UnmarshalJSON(data []byte) error {
+	p.merge = append(json.RawMessage(nil), data...)
+	p.ops = nil
+	return nil
+}
+
+// Ops returns the operations of a JSON Patch, or nil for a JSON Merge Patch.
+func (p *Patch) Ops() []PatchOp {
+	return p.ops
+}
+
+// patchDecoders returns the decoders of an Endpoint whose Param is a Patch
+func patchDecoders() map[string]Decoder {
+	return map[string]Decoder{
+		"application/json":             jsonDecoder,
+		"application/merge-patch+json": jsonDecoder,
+		"application/json-patch+json":  jsonPatchDecoder,
+	}
+}
+
+// jsonPatchDecoder decodes an application/json-patch+json body, which can only be decoded into a *Patch
+var jsonPatchDecoder = decoderFunc(func(rd io.Reader, v interface{}) error {
+	p, ok := v.(*Patch)
+	if !ok {
+		return fmt.Errorf("a JSON Patch can't be decoded into %T", v)
+	}
+	var ops []PatchOp
+	if err := json.NewDecoder(rd).Decode(&ops); err != nil {
+		return err
+	}
+	for i, op := range ops {
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return fmt.Errorf("operation %d (%s) is missing a value", i, op.Op)
+			}
+		case "move", "copy":
+		case "remove":
+		default:
+			return fmt.Errorf("operation %d has an unknown op %q", i, op.Op)
+		}
+	}
+	p.merge, p.ops = nil, ops
+	return nil
+})
+
+// Apply applies the patch to target, which must be a pointer to a struct or a
+// map. Paths are validated against the json tags of target's type. If the patch
+// can't be applied, target is unchanged and the returned error is a 422 *Error.
+func (p *Patch) Apply(target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("olive: patch target must be a non-nil pointer, not %T", target)
+	}
+	data, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeJSONValue(data)
+	if err != nil {
+		return err
+	}
+	t := rv.Type().Elem()
+	if p.ops == nil {
+		merge, err := decodeJSONValue(p.merge)
+		if err != nil {
+			return patchFailure(M{"err": err.Error()})
+		}
+		if err := validateMergePatch(t, merge, ""); err != nil {
+			return patchFailure(M{"err": err.Error()})
+		}
+		doc = mergePatch(doc, merge)
+	}
+	for i, op := range p.ops {
+		if doc, err = applyPatchOp(t, doc, op); err != nil {
+			return patchFailure(M{"index": i, "op": op.Op, "path": op.Path, "err": err.Error()})
+		}
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	// decode into a copy of the target without its JSON members so that removed
+	// members are zeroed and members JSON ignores are preserved
+	patched := reflect.New(t)
+	patched.Elem().Set(rv.Elem())
+	clearJSONMembers(patched.Elem())
+	if err := json.Unmarshal(data, patched.Interface()); err != nil {
+		return patchFailure(M{"err": err.Error()})
+	}
+	rv.Elem().Set(patched.Elem())
+	return nil
+}
+
+func patchFailure(details M) *Error {
+	return &Error{
+		StatusCode: http.StatusUnprocessableEntity,
+		Message:    "failed to apply patch",
+		Details:    details,
+	}
+}
+
+// clearJSONMembers zeroes the parts of v that are encoded as JSON
+func clearJSONMembers(v reflect.Value) {
+	if v.Kind() != reflect.Struct {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			clearJSONMembers(v.Field(i))
+		} else if f.PkgPath == "" {
+			v.Field(i).Set(reflect.Zero(f.Type))
+		}
+	}
+}
+
+// decodeJSONValue decodes JSON into generic values, preserving numbers
+func decodeJSONValue(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// mergePatch implements the MergePatch function of RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	obj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = make(map[string]interface{})
+	}
+	for k, v := range obj {
+		if v == nil {
+			delete(doc, k)
+		} else {
+			doc[k] = mergePatch(doc[k], v)
+		}
+	}
+	return doc
+}
+
+// validateMergePatch checks that the members of a merge patch are fields of t
+func validateMergePatch(t reflect.Type, patch interface{}, path string) error {
+	obj, ok := patch.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for k, v := range obj {
+		ft, ok := jsonChild(t, k)
+		if !ok {
+			return fmt.Errorf("path %q doesn't exist", path+"/"+escapePointer(k))
+		}
+		if err := validateMergePatch(ft, v, path+"/"+escapePointer(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its reference tokens and
+// checks that it refers to a location that t can have
+func parsePointer(t reflect.Type, ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid path %q", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, tok := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		var ok bool
+		if t, ok = jsonChild(t, tokens[i]); !ok {
+			return nil, fmt.Errorf("path %q doesn't exist", ptr)
+		}
+	}
+	return tokens, nil
+}
+
+func escapePointer(tok string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(tok)
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// jsonChild returns the type of the member named by tok of a value of type t
+// encoded as JSON. A nil type accepts any member.
+func jsonChild(t reflect.Type, tok string) (reflect.Type, bool) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return nil, true
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil, true
+	case reflect.Map:
+		return t.Elem(), true
+	case reflect.Slice, reflect.Array:
+		if tok == "-" {
+			return t.Elem(), true
+		}
+		_, err := arrayIndex(tok)
+		return t.Elem(), err == nil
+	case reflect.Struct:
+		return jsonField(t, tok)
+	}
+	return nil, false
+}
+
+// jsonField returns the type of the field of struct t encoded with the JSON name
+func jsonField(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		tagName, _ := split(tag, ",")
+		if f.Anonymous && tagName == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if t, ok := jsonField(ft, name); ok {
+					return t, true
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tagName == "" {
+			tagName = f.Name
+		}
+		if tagName == name {
+			return f.Type, true
+		}
+	}
+	return nil, false
+}
+
+func arrayIndex(tok string) (int, error) {
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') || strings.TrimLeft(tok, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+	return strconv.Atoi(tok)
+}
+
+func applyPatchOp(t reflect.Type, doc interface{}, op PatchOp) (interface{}, error) {
+	path, err := parsePointer(t, op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var from []string
+	if op.Op == "move" || op.Op == "copy" {
+		if from, err = parsePointer(t, op.From); err != nil {
+			return nil, err
+		}
+	}
+	var value interface{}
+	if op.Value != nil {
+		if value, err = decodeJSONValue(op.Value); err != nil {
+			return nil, err
+		}
+	}
+	switch op.Op {
+	case "add":
+		return addValue(doc, path, value)
+	case "remove":
+		doc, _, err = removeValue(doc, path)
+		return doc, err
+	case "replace":
+		if doc, _, err = removeValue(doc, path); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "move":
+		if op.Path != op.From && strings.HasPrefix(op.Path+"/", op.From+"/") {
+			return nil, errors.New("can't move a value into one of its children")
+		}
+		if doc, value, err = removeValue(doc, from); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "copy":
+		if value, err = getValue(doc, from); err != nil {
+			return nil, err
+		}
+		// copy the value so that later operations don't modify both locations
+		data, _ := json.Marshal(value)
+		value, _ = decodeJSONValue(data)
+		return addValue(doc, path, value)
+	case "test":
+		actual, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(actual, value) {
+			return nil, errors.New("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	for _, tok := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[tok]
+			if !ok {
+				return nil, fmt.Errorf("member %q doesn't exist", tok)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(tok)
+			if err != nil {
+				return nil, err
+			}
+			if i >= len(node) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("can't traverse %q of a scalar value", tok)
+		}
+	}
+	return doc, nil
+}
+
+// update replaces the container that is the parent of the path's last token with
+// the result of fn and returns the updated document
+func update(doc interface{}, path []string, fn func(container interface{}, tok string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := getValue(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = update(child, path[1:], fn); err != nil {
+		return nil, err
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0])
+		node[i] = child
+	}
+	return doc, nil
+}
+
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(container interface{}, tok string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			node[tok] = value
+			return node, nil
+		case []interface{}:
+			if tok == "-" {
+				return append(node, value), nil
+			}
+			i, err := arrayIndex(tok)
+			if err != nil {
+				return nil, err
+			}
+			if i > len(node) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("can't add %q to a scalar value", tok)
+	})
+}
+
+func removeValue(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	var removed interface{}
+	doc, err := update(doc, path, func(container interface{}, tok string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			v, ok := node[tok]
+			if !ok {
+				return nil, fmt.Errorf("member %q doesn't exist", tok)
+			}
+			removed = v
+			delete(node, tok)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(tok)
+			if err != nil {
+				return nil, err
+			}
+			if i >= len(node) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			removed = node[i]
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("can't remove %q from a scalar value", tok)
+	})
+	return doc, removed, err
+}
+
+// jsonEqual compares generic JSON values, treating numbers of equal value as equal
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == b {
+			return true
+		}
+		fa, errA := a.Float64()
+		fb, errB := b.Float64()
+		return errA == nil && errB == nil && fa == fb
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if bv, ok := b[k]; !ok || !jsonEqual(v, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
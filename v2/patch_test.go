@@ -0,0 +1,235 @@
+package olive_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+// patchResult is the document a patch endpoint patched and the status of Apply
+type patchResult struct {
+	Doc    interface{} `json:"doc"`
+	Status int         `json:"status"`
+}
+
+// patchClient serves a PATCH endpoint applying the patch to the target returned by newTarget
+func patchClient(t *testing.T, newTarget func() interface{}) *olivetest.Client {
+	o := olive.Martini()
+	o.Patch("/doc", o.Endpoint(func(r olive.Response, p *olive.Patch) {
+		target := newTarget()
+		status := http.StatusOK
+		if err := p.Apply(target); err != nil {
+			status = http.StatusInternalServerError
+			if apiErr, ok := err.(*olive.Error); ok {
+				status = apiErr.StatusCode
+			}
+		}
+		r.Encode(patchResult{Doc: target, Status: status})
+	}).Param(olive.Patch{}))
+	return olivetest.New(t, o)
+}
+
+func decodeJSON(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", s, err)
+	}
+	return v
+}
+
+type patchCase struct {
+	name, doc, patch, want string // an empty want expects the patch to fail
+}
+
+func testPatches(t *testing.T, contentType string, cases []patchCase) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := patchClient(t, func() interface{} {
+				doc := decodeJSON(t, tc.doc)
+				return &doc
+			})
+			var res patchResult
+			c.Patch("/doc", tc.patch).ContentType(contentType).Send().
+				ExpectStatus(http.StatusOK).
+				Decode(&res)
+			if tc.want == "" {
+				if res.Status != http.StatusUnprocessableEntity {
+					t.Errorf("patch applied with status %d, want %d", res.Status, http.StatusUnprocessableEntity)
+				}
+				if doc := decodeJSON(t, tc.doc); !reflect.DeepEqual(res.Doc, doc) {
+					t.Errorf("failed patch changed the document to %v, want %v", res.Doc, doc)
+				}
+				return
+			}
+			if res.Status != http.StatusOK {
+				t.Fatalf("patch failed with status %d", res.Status)
+			}
+			if want := decodeJSON(t, tc.want); !reflect.DeepEqual(res.Doc, want) {
+				t.Errorf("patched document is %v, want %v", res.Doc, want)
+			}
+		})
+	}
+}
+
+// the examples of RFC 6902 appendix A, and copy and pointer escapes
+func TestJSONPatch(t *testing.T) {
+	testPatches(t, "application/json-patch+json", []patchCase{
+		{"A.1 add an object member", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`},
+		{"A.2 add an array element", `{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`},
+		{"A.3 remove an object member", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`},
+		{"A.4 remove an array element", `{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`,
+			`{"foo":["bar","baz"]}`},
+		{"A.5 replace a value", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`},
+		{"A.6 move a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"A.7 move an array element", `{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{"A.8 test a value", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{"A.9 failed test", `{"baz":"qux"}`,
+			`[{"op":"test","path":"/baz","value":"bar"}]`,
+			``},
+		{"A.10 add a nested member object", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`},
+		{"A.11 ignore unrecognized elements", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			`{"foo":"bar","baz":"qux"}`},
+		{"A.12 add to a nonexistent target", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			``},
+		{"A.14 ~ escape ordering", `{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":10}]`,
+			`{"/":9,"~1":10}`},
+		{"A.15 compare strings and numbers", `{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":"10"}]`,
+			``},
+		{"A.16 add an array value", `{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`},
+		{"copy a value", `{"foo":{"bar":1}}`,
+			`[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`,
+			`{"foo":{"bar":1},"baz":{"bar":2}}`},
+		{"escaped slash", `{"a/b":1}`,
+			`[{"op":"replace","path":"/a~1b","value":2},{"op":"add","path":"/c~0d","value":3}]`,
+			`{"a/b":2,"c~d":3}`},
+		{"append to an array", `{"foo":[1]}`,
+			`[{"op":"add","path":"/foo/-","value":2},{"op":"add","path":"/foo/-","value":3}]`,
+			`{"foo":[1,2,3]}`},
+		{"index out of range", `{"foo":[1]}`,
+			`[{"op":"add","path":"/foo/2","value":2}]`,
+			``},
+		{"leading zero index", `{"foo":[1,2]}`,
+			`[{"op":"remove","path":"/foo/01"}]`,
+			``},
+		{"move into a child", `{"foo":{"bar":1}}`,
+			`[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`,
+			``},
+		{"failed test after a change", `{"foo":"bar"}`,
+			`[{"op":"replace","path":"/foo","value":"baz"},{"op":"test","path":"/foo","value":"bar"}]`,
+			``},
+	})
+}
+
+// the examples of RFC 7396 appendix A
+func TestMergePatch(t *testing.T) {
+	testPatches(t, "application/merge-patch+json", []patchCase{
+		{"replace a member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add a member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"delete a member", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"delete one of two members", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"replace an array with a string", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"replace a string with an array", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"merge nested objects", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"replace an array of objects", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"replace an array", `["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{"replace an object with an array", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"replace an object with a string", `{"a":"foo"}`, `"bar"`, `"bar"`},
+		{"keep null members", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{"replace an array with an object", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{"create nested objects", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	})
+}
+
+type patchedAccount struct {
+	Name     string            `json:"name"`
+	Tags     []string          `json:"tags,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Internal string            `json:"-"`
+}
+
+func TestPatchStruct(t *testing.T) {
+	c := patchClient(t, func() interface{} {
+		return &patchedAccount{Name: "alice", Tags: []string{"a"}, Internal: "kept"}
+	})
+	for _, tc := range []struct {
+		name, contentType, patch string
+		status                   int
+		want                     patchedAccount
+	}{
+		{"merge", "application/merge-patch+json", `{"name":"bob","meta":{"k":"v"}}`, http.StatusOK,
+			patchedAccount{Name: "bob", Tags: []string{"a"}, Meta: map[string]string{"k": "v"}}},
+		{"merge as json", "application/json", `{"tags":null}`, http.StatusOK,
+			patchedAccount{Name: "alice"}},
+		{"merge unknown member", "application/merge-patch+json", `{"nope":1}`, http.StatusUnprocessableEntity,
+			patchedAccount{Name: "alice", Tags: []string{"a"}}},
+		{"merge ignored member", "application/merge-patch+json", `{"Internal":"x"}`, http.StatusUnprocessableEntity,
+			patchedAccount{Name: "alice", Tags: []string{"a"}}},
+		{"ops", "application/json-patch+json",
+			`[{"op":"add","path":"/tags/-","value":"b"},{"op":"add","path":"/meta","value":{"a~b":"c"}}]`, http.StatusOK,
+			patchedAccount{Name: "alice", Tags: []string{"a", "b"}, Meta: map[string]string{"a~b": "c"}}},
+		{"ops unknown path", "application/json-patch+json", `[{"op":"add","path":"/nope","value":1}]`,
+			http.StatusUnprocessableEntity, patchedAccount{Name: "alice", Tags: []string{"a"}}},
+		{"ops wrong type", "application/json-patch+json", `[{"op":"replace","path":"/name","value":1}]`,
+			http.StatusUnprocessableEntity, patchedAccount{Name: "alice", Tags: []string{"a"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var res struct {
+				Doc    patchedAccount `json:"doc"`
+				Status int            `json:"status"`
+			}
+			c.Patch("/doc", tc.patch).ContentType(tc.contentType).Send().
+				ExpectStatus(http.StatusOK).
+				Decode(&res)
+			if res.Status != tc.status {
+				t.Errorf("status %d, want %d", res.Status, tc.status)
+			}
+			if !reflect.DeepEqual(res.Doc, tc.want) {
+				t.Errorf("patched %+v, want %+v", res.Doc, tc.want)
+			}
+		})
+	}
+}
+
+func TestPatchDecoders(t *testing.T) {
+	o := olive.Martini()
+	o.Post("/accounts", o.Endpoint(func(r olive.Response) {}).Param(patchedAccount{}))
+	o.Patch("/accounts", o.Endpoint(func(r olive.Response, p *olive.Patch) {}).Param(olive.Patch{}))
+	c := olivetest.New(t, o)
+
+	c.Do(http.MethodOptions, "/accounts").Send().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Accept-Patch", "application/json, application/json-patch+json, application/merge-patch+json")
+	c.Post("/accounts", `{"name":"a"}`).ContentType("application/merge-patch+json").Send().
+		ExpectError(http.StatusUnsupportedMediaType, 0)
+	c.Patch("/accounts", `<Patch></Patch>`).ContentType("application/xml").Send().
+		ExpectError(http.StatusUnsupportedMediaType, 0)
+	c.Patch("/accounts", `name=a`).ContentType("application/x-www-form-urlencoded").Send().
+		ExpectError(http.StatusUnsupportedMediaType, 0)
+}
@@ -0,0 +1,322 @@
+package olive
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// A RateLimit limits the rate of requests an Endpoint accepts from each client.
+// Responses carry RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers,
+// and requests over the limit fail with a 429 *Error and a Retry-After header.
+// Requests failing authentication are limited by the key of an unauthenticated
+// request, so that clients guessing credentials are limited too.
+//
+//	o.RateLimit = &olive.RateLimit{
+//		Limiter: &olive.TokenBucket{Rate: 10, Per: time.Second, Burst: 20},
+//		Key:     olive.KeyByPrincipal,
+//	}
+type RateLimit struct {
+	Limiter  Limiter     // the rate limiting algorithm
+	Key      RateKeyFunc // identifies the client, defaults to KeyByIP
+	PerRoute bool        // if true, each route has a separate limit rather than sharing the client's limit
+}
+
+// A RateKeyFunc returns the key identifying the client of a request for rate
+// limiting. The principal is nil if the request is not authenticated.
+// Requests with an empty key are not limited.
+type RateKeyFunc func(req *http.Request, p Principal) string
+
+// KeyByIP identifies clients by their IP address.
+func KeyByIP(req *http.Request, p Principal) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// KeyByPrincipal identifies clients by the subject of their principal, falling
+// back to their IP address for unauthenticated requests.
+func KeyByPrincipal(req *http.Request, p Principal) string {
+	if p != nil {
+		return "principal:" + p.Subject()
+	}
+	return KeyByIP(req, p)
+}
+
+// KeyByHeader identifies clients by the value of a request header, such as an API key.
+func KeyByHeader(name string) RateKeyFunc {
+	return func(req *http.Request, p Principal) string {
+		if v := req.Header.Get(name); v != "" {
+			return "header:" + v
+		}
+		return ""
+	}
+}
+
+// RateLimitStatus describes the state of a client's rate limit after a request.
+type RateLimitStatus struct {
+	Allowed   bool          // whether the request is allowed
+	Limit     int           // the number of requests allowed in the limit's window
+	Remaining int           // the number of requests remaining in the window
+	Reset     time.Duration // time until the limit is fully replenished
+	Retry     time.Duration // time until a request will be allowed again, if it isn't
+}
+
+// A Limiter implements a rate limiting algorithm.
+type Limiter interface {
+	// Take consumes one request from key's limit.
+	Take(key string, now time.Time) (RateLimitStatus, error)
+}
+
+// A LimitStore stores the state of rate limits. Implementations backed by a
+// shared store allow limits to be enforced across several servers.
+type LimitStore interface {
+	// Update atomically replaces the state of key with the result of fn. The state passed
+	// to fn is nil if there is none. The new state expires after ttl.
+	Update(key string, ttl time.Duration, fn func(state []byte) []byte) error
+}
+
+// TokenBucket is a Limiter that allows Rate requests Per interval on average with
+// bursts of up to Burst requests. A Rate or Per of zero denies all requests. Per
+// must be at least Rate nanoseconds.
+type TokenBucket struct {
+	Rate  int
+	Per   time.Duration
+	Burst int        // bucket capacity, defaults to Rate
+	Store LimitStore // defaults to an in-memory store
+
+	once sync.Once
+}
+
+func (b *TokenBucket) Take(key string, now time.Time) (st RateLimitStatus, err error) {
+	b.once.Do(func() {
+		if b.Store == nil {
+			b.Store = NewMemoryLimitStore()
+		}
+	})
+	if b.Rate <= 0 || b.Per <= 0 {
+		return denyAll(b.Per), nil
+	}
+	capacity := float64(b.Burst)
+	if b.Burst == 0 {
+		capacity = float64(b.Rate)
+	}
+	perToken := b.Per / time.Duration(b.Rate)
+	refill := time.Duration(capacity) * perToken
+	err = b.Store.Update(key, refill, func(state []byte) []byte {
+		tokens, last := capacity, now
+		if len(state) == 16 {
+			tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+			last = time.Unix(0, int64(binary.BigEndian.Uint64(state[8:])))
+			tokens = math.Min(capacity, tokens+float64(now.Sub(last))/float64(perToken))
+		}
+		st.Limit = int(capacity)
+		if tokens >= 1 {
+			tokens--
+			st.Allowed = true
+		} else {
+			st.Retry = time.Duration((1 - tokens) * float64(perToken))
+		}
+		st.Remaining = int(tokens)
+		st.Reset = time.Duration((capacity - tokens) * float64(perToken))
+
+		state = make([]byte, 16)
+		binary.BigEndian.PutUint64(state, math.Float64bits(tokens))
+		binary.BigEndian.PutUint64(state[8:], uint64(now.UnixNano()))
+		return state
+	})
+	return
+}
+
+// SlidingWindow is a Limiter that allows Limit requests in any Window. It
+// approximates a sliding window by weighting the count of the previous fixed
+// window by how much of it overlaps the sliding window. A Limit or Window of
+// zero denies all requests.
+type SlidingWindow struct {
+	Limit  int
+	Window time.Duration
+	Store  LimitStore // defaults to an in-memory store
+
+	once sync.Once
+}
+
+func (w *SlidingWindow) Take(key string, now time.Time) (st RateLimitStatus, err error) {
+	w.once.Do(func() {
+		if w.Store == nil {
+			w.Store = NewMemoryLimitStore()
+		}
+	})
+	if w.Limit <= 0 || w.Window <= 0 {
+		return denyAll(w.Window), nil
+	}
+	start := now.Truncate(w.Window)
+	err = w.Store.Update(key, 2*w.Window, func(state []byte) []byte {
+		var prev, cur uint64
+		if len(state) == 24 {
+			stateStart := time.Unix(0, int64(binary.BigEndian.Uint64(state)))
+			prev, cur = binary.BigEndian.Uint64(state[8:]), binary.BigEndian.Uint64(state[16:])
+			switch {
+			case stateStart.Equal(start):
+			case stateStart.Add(w.Window).Equal(start):
+				prev, cur = cur, 0
+			default:
+				prev, cur = 0, 0
+			}
+		}
+		overlap := 1 - float64(now.Sub(start))/float64(w.Window)
+		count := float64(prev)*overlap + float64(cur)
+
+		st.Limit = w.Limit
+		if count+1 <= float64(w.Limit) {
+			cur++
+			count++
+			st.Allowed = true
+		} else if prev > 0 {
+			// wait until enough of the previous window slides out
+			need := count + 1 - float64(w.Limit)
+			st.Retry = time.Duration(need / float64(prev) * float64(w.Window))
+		} else {
+			st.Retry = start.Add(w.Window).Sub(now)
+		}
+		st.Remaining = int(math.Max(0, float64(w.Limit)-count))
+		st.Reset = start.Add(w.Window).Sub(now)
+		if prev > 0 {
+			st.Reset += w.Window
+		}
+
+		state = make([]byte, 24)
+		binary.BigEndian.PutUint64(state, uint64(start.UnixNano()))
+		binary.BigEndian.PutUint64(state[8:], prev)
+		binary.BigEndian.PutUint64(state[16:], cur)
+		return state
+	})
+	return
+}
+
+// denyAll is the status of a limit that allows no requests
+func denyAll(window time.Duration) RateLimitStatus {
+	if window <= 0 {
+		window = time.Minute
+	}
+	return RateLimitStatus{Reset: window, Retry: window}
+}
+
+// MemoryLimitStore is a LimitStore holding rate limit state in memory.
+type MemoryLimitStore struct {
+	mu      sync.Mutex
+	entries map[string]limitEntry
+	updates int
+}
+
+type limitEntry struct {
+	state   []byte
+	expires time.Time
+}
+
+// NewMemoryLimitStore returns an empty in-memory LimitStore.
+func NewMemoryLimitStore() *MemoryLimitStore {
+	return &MemoryLimitStore{entries: make(map[string]limitEntry)}
+}
+
+func (s *MemoryLimitStore) Update(key string, ttl time.Duration, fn func([]byte) []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// periodically sweep expired entries so the store doesn't grow without bound
+	if s.updates++; s.updates%1024 == 0 {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	var state []byte
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		state = e.state
+	}
+	s.entries[key] = limitEntry{fn(state), now.Add(ttl)}
+	return nil
+}
+
+// validate panics if the limit has no Limiter, or a TokenBucket whose interval
+// is too short to replenish a token every nanosecond
+func (rl *RateLimit) validate() {
+	if rl.Limiter == nil {
+		panic("olive: a RateLimit must have a Limiter")
+	}
+	if b, ok := rl.Limiter.(*TokenBucket); ok && b.Rate > 0 && b.Per > 0 && b.Per < time.Duration(b.Rate) {
+		panic(fmt.Sprintf("olive: a TokenBucket can't allow %d requests per %v", b.Rate, b.Per))
+	}
+}
+
+// rateLimitMiddleware enforces the rate limit on requests that authenticated or
+// didn't need to. Requests failing authentication are limited by authMiddleware
+// before they're rejected so that guessing credentials is limited too.
+func rateLimitMiddleware(rl *RateLimit) martini.Handler {
+	if rl != nil {
+		rl.validate()
+	}
+	return func(req *http.Request, w http.ResponseWriter, c martini.Context, e *errEncoder) {
+		enforceRateLimit(rl, req, w, c, e, principal(c))
+	}
+}
+
+// enforceRateLimit consumes a request from the limit of the client making it,
+// aborting the request if it's over the limit. Limiter failures are logged and
+// the request is allowed.
+func enforceRateLimit(rl *RateLimit, req *http.Request, w http.ResponseWriter, c martini.Context, e *errEncoder, p Principal) {
+	if rl == nil {
+		return
+	}
+	keyFn := rl.Key
+	if keyFn == nil {
+		keyFn = KeyByIP
+	}
+	key := keyFn(req, p)
+	if key == "" {
+		return
+	}
+	if rl.PerRoute {
+		if v := c.Get(reflect.TypeOf((*martini.Route)(nil)).Elem()); v.IsValid() {
+			rt := v.Interface().(martini.Route)
+			key = rt.Method() + " " + rt.Pattern() + " " + key
+		}
+	}
+	now := time.Now()
+	st, err := rl.Limiter.Take(key, now)
+	if err != nil {
+		e.l.Error("rate limiter failed", "err", err)
+		return
+	}
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(st.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(st.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(st.Reset)))
+	if !st.Allowed {
+		retry := ceilSeconds(st.Retry)
+		h.Set("Retry-After", strconv.Itoa(retry))
+		e.Abort(&Error{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "rate limit exceeded",
+			Details: M{
+				"limit":       st.Limit,
+				"retry_after": retry,
+				"reset":       now.Add(st.Retry).UTC().Format(time.RFC3339),
+			},
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
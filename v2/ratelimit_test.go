@@ -0,0 +1,73 @@
+package olive_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestRateLimitFailedAuthentication(t *testing.T) {
+	o := olive.Martini()
+	o.Authenticators = []olive.Authenticator{&olive.BasicAuth{
+		Validate: func(user, password string) (olive.Principal, error) {
+			if password != "right" {
+				return nil, olive.ErrInvalidCredentials
+			}
+			return &olive.User{Name: user}, nil
+		},
+	}}
+	o.RateLimit = &olive.RateLimit{
+		Limiter: &olive.TokenBucket{Rate: 3, Per: time.Hour},
+		Key:     olive.KeyByPrincipal,
+	}
+	o.Get("/secret", o.Endpoint(func(r olive.Response) { r.Encode("secret") }))
+	c := olivetest.New(t, o)
+
+	guess := func(password string) *olivetest.Response {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth("alice", password)
+		return c.Get("/secret").Header("Authorization", req.Header.Get("Authorization")).Send()
+	}
+	for i := 0; i < 3; i++ {
+		guess("wrong").ExpectStatus(http.StatusUnauthorized)
+	}
+	guess("wrong").ExpectError(http.StatusTooManyRequests, 0)
+
+	// authenticated requests are limited by principal
+	guess("right").ExpectStatus(http.StatusOK).ExpectHeader("RateLimit-Remaining", "2")
+}
+
+func TestRateLimitZeroRateDeniesAll(t *testing.T) {
+	for _, l := range []olive.Limiter{
+		&olive.TokenBucket{Per: time.Second},
+		&olive.TokenBucket{Rate: 1},
+		&olive.SlidingWindow{Window: time.Second},
+		&olive.SlidingWindow{Limit: 1},
+	} {
+		o := olive.Martini()
+		o.RateLimit = &olive.RateLimit{Limiter: l}
+		o.Get("/", o.Endpoint(func(r olive.Response) { r.Encode("ok") }))
+		olivetest.New(t, o).Get("/").Send().ExpectError(http.StatusTooManyRequests, 0)
+	}
+}
+
+func TestRateLimitRejectsInvalidLimits(t *testing.T) {
+	for _, rl := range []*olive.RateLimit{
+		{},
+		{Limiter: &olive.TokenBucket{Rate: 10, Per: 5 * time.Nanosecond}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registered an endpoint with rate limit %+v", rl)
+				}
+			}()
+			o := olive.Martini()
+			o.RateLimit = rl
+			o.Get("/", o.Endpoint(func(r olive.Response) {}))
+		}()
+	}
+}
@@ -1,31 +1,55 @@
 package olive
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"reflect"
 
 	"github.com/go-martini/martini"
 	log "github.com/inconshreveable/log15/v3"
+	logext "github.com/inconshreveable/log15/v3/ext"
 	stack "gopkg.in/stack.v1"
 )
 
-// A recoveredPanic is injected into the martini context by the RecoveryMiddleware when
-// it recovers from an unhandled panic.
-type recoveredPanic struct {
-	Cause interface{}
+// A Panic describes an unhandled panic recovered while handling a request.
+// It is injected into the martini context of the endpoint's panic handler.
+type Panic struct {
+	ID    string          // unique identifier of the panic, returned to the client
+	Cause interface{}     // the value passed to panic()
+	Stack stack.CallStack // the stack of the panicking goroutine
+}
+
+// A CrashReporter is notified of every unhandled panic, for example to forward
+// it to an external crash reporting service.
+type CrashReporter interface {
+	ReportPanic(p *Panic, req *http.Request)
+}
+
+// CrashReporterFunc adapts a function into a CrashReporter.
+type CrashReporterFunc func(*Panic, *http.Request)
+
+func (f CrashReporterFunc) ReportPanic(p *Panic, req *http.Request) {
+	f(p, req)
 }
 
 // RecoveryMiddleware catches unhandled panics in the handler chain.
-// When a panic is recovered from, the onPanic handler is invoked.
-// The panic will be injected into the onPanic handler with type *Panic.
-// See the DefaultRecoveryMiddleware for an example.
-func recoveryMiddleware(onPanic martini.Handler) martini.Handler {
-	return func(w http.ResponseWriter, c martini.Context, l log.Logger) {
+// When a panic is recovered from, it is logged and reported to the crash
+// reporters, then the onPanic handler is invoked with the *Panic injected.
+// See defaultPanicHandler for an example.
+func recoveryMiddleware(onPanic martini.Handler, reporters []CrashReporter) martini.Handler {
+	return func(c martini.Context, req *http.Request, l log.Logger) {
 		defer func() {
 			if r := recover(); r != nil {
-				c.Map(&recoveredPanic{r})
+				p := &Panic{
+					ID:    logext.RandId(8),
+					Cause: r,
+					Stack: stack.Trace().TrimRuntime(),
+				}
+				l.Crit("handler crashed", "panic", p.Cause, "panic_id", p.ID, "stack", fmt.Sprintf("%+v", p.Stack))
+				for _, rep := range reporters {
+					reportPanic(rep, p, req, l)
+				}
+				c.Map(p)
 				c.Invoke(onPanic)
 			}
 		}()
@@ -33,26 +57,47 @@ func recoveryMiddleware(onPanic martini.Handler) martini.Handler {
 	}
 }
 
-// Default handler for recovering from unhandled panics. The
-// panic cause and stack trace are written to the response and logged.
-func defaultRecoveryMiddleware(debugMode bool) martini.Handler {
-	return recoveryMiddleware(func(p *recoveredPanic, w http.ResponseWriter, l log.Logger) {
-		s := stack.Trace().TrimRuntime()
-		l.Crit("handler crashed", "panic", p.Cause, "stack", fmt.Sprintf("%+v", s))
-		debugStack := make([]string, 0)
-		for _, frame := range s {
-			fr := fmt.Sprintf("%+v", frame)
-			l.Debug(fr, "panic", p.Cause)
-			debugStack = append(debugStack, fr)
+// reportPanic calls the crash reporter, guarding against it panicking as well
+func reportPanic(rep CrashReporter, p *Panic, req *http.Request, l log.Logger) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.Error("crash reporter failed", "panic", r, "panic_id", p.ID)
+		}
+	}()
+	rep.ReportPanic(p, req)
+}
+
+// Default handler for recovering from unhandled panics. It responds with a 500
+// *Error including the panic's ID, serialized by the negotiated Encoder. In debug
+// mode the panic cause and stack trace are included in the error's details.
+// Nothing is written if the response has already been started.
+func defaultPanicHandler(debugMode bool) martini.Handler {
+	return func(p *Panic, c martini.Context, w http.ResponseWriter, l log.Logger, loc *locale) {
+		apiErr := &Error{
+			StatusCode: http.StatusInternalServerError,
+			Message:    http.StatusText(http.StatusInternalServerError),
+			Details:    M{"panic_id": p.ID},
 		}
 		if debugMode {
-			http.Error(w, fmt.Sprintf("panic: %v\n\n", p.Cause)+strings.Join(debugStack, "\n"), 500)
+			debugStack := make([]string, 0, len(p.Stack))
+			for _, frame := range p.Stack {
+				debugStack = append(debugStack, fmt.Sprintf("%+v", frame))
+			}
+			apiErr.Details["panic"] = fmt.Sprint(p.Cause)
+			apiErr.Details["stack"] = debugStack
+		}
+
+		// use the request's error encoder if the panic happened after content negotiation
+		var e *errEncoder
+		if v := c.Get(reflect.TypeOf(e)); v.IsValid() {
+			e = v.Interface().(*errEncoder)
 		} else {
-			enc := json.NewEncoder(w)
-			enc.Encode(&Error{
-				StatusCode: http.StatusInternalServerError,
-				Message:    http.StatusText(http.StatusInternalServerError),
-			})
+			rw := w.(martini.ResponseWriter)
+			if !rw.Written() {
+				rw.Header().Set("Content-Type", "application/json")
+			}
+			e = &errEncoder{enc: jsonEncoder, l: l, w: rw, loc: loc}
 		}
-	})
+		e.write(apiErr)
+	}
 }
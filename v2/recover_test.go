@@ -0,0 +1,76 @@
+package olive_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	log "github.com/inconshreveable/log15/v3"
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+type panicReports struct {
+	mu     sync.Mutex
+	panics []*olive.Panic
+	paths  []string
+}
+
+func (r *panicReports) ReportPanic(p *olive.Panic, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.panics = append(r.panics, p)
+	r.paths = append(r.paths, req.URL.Path)
+}
+
+func TestRecovery(t *testing.T) {
+	reports := new(panicReports)
+	o := olive.Martini()
+	o.CrashReporters = []olive.CrashReporter{
+		olive.CrashReporterFunc(func(*olive.Panic, *http.Request) { panic("reporter down") }),
+		reports,
+	}
+	o.Get("/crash", o.Endpoint(func(r olive.Response) { panic("boom") }))
+	o.Get("/debug", o.Endpoint(func(r olive.Response) { panic("boom") }).Debug(true))
+	c := olivetest.New(t, o)
+
+	resp := c.Get("/crash").Send().
+		ExpectError(http.StatusInternalServerError, 0).
+		ExpectLog(log.LvlCrit, "handler crashed").
+		ExpectLog(log.LvlError, "crash reporter failed")
+	apiErr := resp.Error()
+	if len(reports.panics) != 1 {
+		t.Fatalf("crash reporter was notified of %d panics, want 1", len(reports.panics))
+	}
+	p := reports.panics[0]
+	if p.Cause != "boom" || len(p.Stack) == 0 || reports.paths[0] != "/crash" {
+		t.Errorf("reported panic %v on %s with %d frames", p.Cause, reports.paths[0], len(p.Stack))
+	}
+	if apiErr.Details["panic_id"] != p.ID {
+		t.Errorf("panic_id is %v, want %v", apiErr.Details["panic_id"], p.ID)
+	}
+	if _, ok := apiErr.Details["stack"]; ok {
+		t.Error("stack trace sent to the client outside of debug mode")
+	}
+
+	c.Get("/debug").Send().
+		ExpectError(http.StatusInternalServerError, 0).
+		ExpectErrorDetail("panic", "boom")
+}
+
+func TestPanicHandler(t *testing.T) {
+	o := olive.Martini()
+	o.PanicHandler = func(p *olive.Panic, w http.ResponseWriter) {
+		w.Header().Set("X-Panic-Id", p.ID)
+		w.WriteHeader(http.StatusTeapot)
+	}
+	o.Get("/crash", o.Endpoint(func(r olive.Response) { panic("boom") }))
+	o.Get("/custom", o.Endpoint(func(r olive.Response) { panic("boom") }).
+		PanicHandler(func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) }))
+	c := olivetest.New(t, o)
+
+	if c.Get("/crash").Send().ExpectStatus(http.StatusTeapot).Header().Get("X-Panic-Id") == "" {
+		t.Error("panic handler wasn't passed the panic")
+	}
+	c.Get("/custom").Send().ExpectStatus(http.StatusServiceUnavailable)
+}
@@ -1,7 +1,12 @@
 package olive
 
 import (
+	"bytes"
+	"context"
 	"net/http"
+	"reflect"
+	"strconv"
+	"time"
 
 	"github.com/go-martini/martini"
 	log "github.com/inconshreveable/log15/v3"
@@ -13,6 +18,7 @@ type Response interface {
 	log.Logger
 
 	// Encode uses the negotiated codec to serialize and write the value to the response.
+	// It returns ErrNotModified if the request is answered with 304 Not Modified instead.
 	Encode(v interface{}) error
 
 	// Abort terminates a handler immediately with an error and no further processing is done.
@@ -21,22 +27,153 @@ type Response interface {
 	// determine the status code and shape of the error response. Otherwise, the response will
 	// be a 500 internal server error which includes the error argument as one of its details.
 	Abort(error)
+
+	// Context returns the request-scoped context. It is done when the client goes away
+	// or the endpoint's timeout elapses.
+	Context() context.Context
+
+	// Language returns the language tag negotiated from the Accept-Language header,
+	// or the empty string if none of the endpoint's languages are acceptable.
+	Language() string
+
+	// Translate returns the translation of msg into the negotiated language from the
+	// endpoint's MessageBundle. If there is no translation, msg is returned.
+	Translate(msg string) string
+
+	// Principal returns the authenticated principal making the request, or nil if
+	// the endpoint doesn't require authentication.
+	Principal() Principal
+
+	// SetETag sets the ETag of the resource and evaluates the request's conditional headers,
+	// answering with 304 Not Modified or aborting with 412 Precondition Failed if appropriate.
+	SetETag(etag string, weak bool)
+
+	// SetETagFor is like SetETag with the ETag the endpoint generates for v's representation.
+	SetETagFor(v interface{})
+
+	// SetLastModified sets the modification time of the resource and evaluates the request's
+	// conditional headers like SetETag.
+	SetLastModified(time.Time)
+
+	// URLFor returns the path of the named route with its parameters filled in by params,
+	// which are formatted with fmt.Sprint and escaped. It fails if there is no such route
+	// or the number of params doesn't match the route's.
+	URLFor(name string, params ...interface{}) (string, error)
+
+	// AbsoluteURLFor is like URLFor but returns an absolute URL with the scheme and host
+	// the client used, as forwarded by a trusted proxy.
+	AbsoluteURLFor(name string, params ...interface{}) (string, error)
+
+	// AddLink adds a link to the representation encoded by Encode in the endpoint's LinkStyle.
+	AddLink(rel, href string)
+
+	// Created sets the Location header and encodes v with the status 201 Created.
+	Created(location string, v interface{}) error
 }
 
 type response struct {
 	martini.ResponseWriter
-	enc Encoder
+	enc ContentEncoder
 	log.Logger
 	*errEncoder
+	ctx context.Context
+	req *http.Request
+	p   Principal
+
+	etags ETagMode
+	v     validators
+
+	status    int
+	routes    martini.Routes
+	proxies   trustedProxies
+	linkStyle LinkStyle
+	links     map[string]string
 }
 
 // The ResponseMiddleware injects an olive.Response into the martini context
-func responseMiddleware() martini.Handler {
-	return func(w http.ResponseWriter, enc Encoder, l log.Logger, e *errEncoder, c martini.Context) {
-		c.MapTo(&response{w.(martini.ResponseWriter), enc, l, e}, (*Response)(nil))
+func responseMiddleware(etags ETagMode, links LinkStyle, proxies []string) martini.Handler {
+	trusted := parseTrustedProxies(proxies)
+	return func(w http.ResponseWriter, req *http.Request, enc ContentEncoder, l log.Logger, e *errEncoder, ctx context.Context, routes martini.Routes, c martini.Context) {
+		c.MapTo(&response{
+			ResponseWriter: w.(martini.ResponseWriter),
+			enc:            enc,
+			Logger:         l,
+			errEncoder:     e,
+			ctx:            ctx,
+			req:            req,
+			p:              principal(c),
+			etags:          etags,
+			routes:         routes,
+			proxies:        trusted,
+			linkStyle:      links,
+		}, (*Response)(nil))
 	}
 }
 
+// Encode serializes the value completely before writing it so that the
+// Content-Length and ETag can be set and encoding failures result in an error
+// response. The body is not written in response to HEAD requests. Conditional
+// GETs matching the generated ETag are answered with 304 Not Modified, and
+// ErrNotModified is returned.
 func (r *response) Encode(v interface{}) error {
-	return r.enc.Encode(r.ResponseWriter, v)
+	v = r.embedLinks(v)
+	var buf bytes.Buffer
+	if err := r.enc.Encode(&buf, v); err != nil {
+		r.Error("failed to encode response", "err", err)
+		r.write(&Error{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "failed to encode response",
+			Details:    M{"err": err.Error()},
+		})
+		return err
+	}
+	if !r.Written() {
+		if r.etags != NoETags && r.v.etag == "" && safeMethod(r.req.Method) {
+			r.v.etag = representationETag(r.enc.ContentType, buf.Bytes(), r.etags == WeakETags)
+			r.Header().Set("ETag", r.v.etag)
+			if checkPreconditions(r.req, r.v) == http.StatusNotModified {
+				r.writeNotModified()
+				return ErrNotModified
+			}
+		}
+		r.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		if r.status != 0 {
+			r.WriteHeader(r.status)
+		}
+	}
+	if r.req.Method == http.MethodHead {
+		if !r.Written() {
+			r.WriteHeader(http.StatusOK)
+		}
+		return nil
+	}
+	_, err := r.Write(buf.Bytes())
+	return err
+}
+
+func (r *response) Context() context.Context {
+	return r.ctx
+}
+
+func (r *response) Language() string {
+	return r.loc.lang
+}
+
+func (r *response) Translate(msg string) string {
+	if t, ok := r.loc.translate(msg); ok {
+		return t
+	}
+	return msg
+}
+
+func (r *response) Principal() Principal {
+	return r.p
+}
+
+// principal returns the Principal injected into the context, if there is one
+func principal(c martini.Context) Principal {
+	if v := c.Get(reflect.TypeOf((*Principal)(nil)).Elem()); v.IsValid() {
+		return v.Interface().(Principal)
+	}
+	return nil
 }
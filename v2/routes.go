@@ -0,0 +1,147 @@
+package olive
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-martini/martini"
+)
+
+// registeredRoute records an Endpoint registered on a route by an Olive
+type registeredRoute struct {
+	method  string
+	pattern string
+	route   martini.Route
+	e       *endpoint // nil if the Endpoint isn't implemented by olive
+}
+
+// routeTable records all of the routes registered by an Olive and its groups
+type routeTable struct {
+	mu     sync.RWMutex
+	routes []*registeredRoute
+}
+
+// routeMatcher is implemented by the routes of martini's Router
+type routeMatcher interface {
+	Match(method, path string) (martini.RouteMatch, map[string]string)
+}
+
+func (t *routeTable) add(method, pattern string, route martini.Route, e Endpoint) {
+	ep, _ := e.(*endpoint)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes = append(t.routes, &registeredRoute{
+		method:  method,
+		pattern: pattern,
+		route:   route,
+		e:       ep,
+	})
+}
+
+// A RouteInfo describes a route registered with an olive Endpoint, for
+// generating documentation and clients.
+type RouteInfo struct {
+	Method    string       // "*" for routes registered with Any
+	Pattern   string       // martini pattern, including the prefix of the group
+	Name      string       // name given with martini.Route.Name
+	Param     reflect.Type // type of the Endpoint's Param, nil if it has none
+	Returns   reflect.Type // type of the Endpoint's response body, nil if unknown
+	Encoders  []string     // content types responses can be encoded in, in order of preference
+	Decoders  []string     // content types request bodies can be decoded from, sorted
+	WebSocket bool         // whether the Endpoint upgrades requests to WebSocket connections
+}
+
+// Routes returns the routes registered with olive Endpoints by the Olive and
+// its groups, in the order they were registered.
+func (o *Olive) Routes() []RouteInfo {
+	o.routes.mu.RLock()
+	defer o.routes.mu.RUnlock()
+	infos := make([]RouteInfo, 0, len(o.routes.routes))
+	for _, r := range o.routes.routes {
+		if r.e == nil {
+			continue
+		}
+		info := RouteInfo{
+			Method:    r.method,
+			Pattern:   r.pattern,
+			Name:      r.route.GetName(),
+			Encoders:  make([]string, len(r.e.encs)),
+			Decoders:  make([]string, 0, len(r.e.decs)),
+			WebSocket: r.e.ws != nil,
+		}
+		if r.e.param != nil {
+			info.Param = reflect.TypeOf(r.e.param)
+		}
+		if r.e.returns != nil {
+			info.Returns = reflect.TypeOf(r.e.returns)
+		}
+		for i, enc := range r.e.encs {
+			info.Encoders[i] = enc.ContentType
+		}
+		for ct := range r.e.decs {
+			info.Decoders = append(info.Decoders, ct)
+		}
+		sort.Strings(info.Decoders)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// match returns the registered routes whose pattern matches path. Routes of
+// Routers other than martini's, which can't be matched, are left out.
+func (t *routeTable) match(path string) []*registeredRoute {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	matches := make([]*registeredRoute, 0)
+	for _, r := range t.routes {
+		m, ok := r.route.(routeMatcher)
+		if !ok {
+			continue
+		}
+		if rm, _ := m.Match(r.route.Method(), path); rm != martini.NoMatch {
+			matches = append(matches, r)
+		}
+	}
+	return matches
+}
+
+// allowedMethods returns the value of the Allow header for a resource with
+// routes for the methods. HEAD is implied by GET, and OPTIONS is always allowed.
+func allowedMethods(methods []string) string {
+	set := map[string]bool{http.MethodOptions: true}
+	for _, m := range methods {
+		if m == "*" {
+			continue
+		}
+		set[m] = true
+		if m == http.MethodGet {
+			set[http.MethodHead] = true
+		}
+	}
+	allowed := make([]string, 0, len(set))
+	for m := range set {
+		allowed = append(allowed, m)
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+// acceptedContentTypes returns the content types decoded by the endpoint of the
+// first route with the given method, or false if there is no such route.
+func acceptedContentTypes(rts []*registeredRoute, method string) (string, bool) {
+	for _, r := range rts {
+		if r.method != method || r.e == nil {
+			continue
+		}
+		cts := make([]string, 0, len(r.e.decs))
+		for ct := range r.e.decs {
+			cts = append(cts, ct)
+		}
+		sort.Strings(cts)
+		return strings.Join(cts, ", "), true
+	}
+	return "", false
+}
@@ -0,0 +1,93 @@
+package olive_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+	"github.com/inconshreveable/olive/v2/olivetest"
+)
+
+func TestNoRouteSkipsEndpointMiddleware(t *testing.T) {
+	o := olive.Martini()
+	o.Authenticators = []olive.Authenticator{&olive.BearerAuth{
+		Validate: func(token string) (olive.Principal, error) {
+			return nil, olive.ErrInvalidCredentials
+		},
+	}}
+	o.RateLimit = &olive.RateLimit{
+		Limiter: &olive.TokenBucket{Rate: 1, Per: time.Hour},
+		Key:     olive.KeyByIP,
+	}
+	o.CORS = &olive.CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}}
+	o.Get("/me", o.Endpoint(func(r olive.Response) { r.Encode("me") }))
+	c := olivetest.New(t, o)
+
+	for i := 0; i < 2; i++ {
+		c.Get("/nope").Send().
+			ExpectError(http.StatusNotFound, 0).
+			ExpectHeader("RateLimit-Remaining", "")
+		c.Delete("/me").Send().
+			ExpectError(http.StatusMethodNotAllowed, 0).
+			ExpectHeader("Allow", "GET, HEAD, OPTIONS")
+	}
+	c.Do(http.MethodOptions, "/me").
+		Header("Origin", "https://app.example.com").
+		Header("Access-Control-Request-Method", http.MethodGet).
+		Send().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Access-Control-Allow-Origin", "https://app.example.com")
+	c.Get("/me").Send().ExpectError(http.StatusUnauthorized, 0)
+}
+
+func TestOptionsAndMethodNotAllowed(t *testing.T) {
+	o := olive.Martini()
+	o.Get("/accounts/:id", o.Endpoint(func(r olive.Response) {}))
+	o.Patch("/accounts/:id", o.Endpoint(func(r olive.Response, p *olive.Patch) {}).Param(olive.Patch{}))
+	o.Post("/accounts/:id/notes", o.Endpoint(func(r olive.Response) {}).Param(echoMessage{}))
+	o.Get("/files/**", o.Endpoint(func(r olive.Response) {}))
+	c := olivetest.New(t, o)
+
+	c.Do(http.MethodOptions, "/accounts/1").Send().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Allow", "GET, HEAD, OPTIONS, PATCH").
+		ExpectHeader("Accept-Patch", "application/json, application/json-patch+json, application/merge-patch+json").
+		ExpectHeader("Accept-Post", "")
+	c.Do(http.MethodOptions, "/accounts/1/notes/").Send().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Allow", "OPTIONS, POST").
+		ExpectHeader("Accept-Post", "application/json, application/x-www-form-urlencoded, application/xml, text/xml").
+		ExpectHeader("Accept-Patch", "")
+	c.Delete("/accounts/1").Send().
+		ExpectError(http.StatusMethodNotAllowed, 0).
+		ExpectHeader("Allow", "GET, HEAD, OPTIONS, PATCH").
+		ExpectErrorDetail("method", http.MethodDelete)
+	c.Do(http.MethodOptions, "/accounts").Send().
+		ExpectError(http.StatusNotFound, 0).
+		ExpectHeader("Allow", "")
+	c.Do(http.MethodOptions, "/files/a/b").Send().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Allow", "GET, HEAD, OPTIONS")
+}
+
+func TestHead(t *testing.T) {
+	o := olive.Martini()
+	o.Get("/doc", o.Endpoint(func(r olive.Response) {
+		r.Encode(echoMessage{Msg: "hello"})
+	}).ETags(olive.StrongETags))
+	c := olivetest.New(t, o)
+
+	get := c.Get("/doc").Send().ExpectStatus(http.StatusOK)
+	head := c.Do(http.MethodHead, "/doc").Send().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Content-Type", get.Header().Get("Content-Type")).
+		ExpectHeader("Content-Length", get.Header().Get("Content-Length")).
+		ExpectHeader("ETag", get.Header().Get("ETag"))
+	if get.Header().Get("Content-Length") == "" {
+		t.Error("GET response has no Content-Length")
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("HEAD response has a body: %q", head.Body)
+	}
+}
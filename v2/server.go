@@ -0,0 +1,205 @@
+package olive
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/inconshreveable/log15/v3"
+)
+
+// A ShutdownHook releases a resource when the server shuts down. It should
+// return when ctx is done.
+type ShutdownHook func(ctx context.Context) error
+
+// ServerOptions configures how an OliveMartini serves requests.
+type ServerOptions struct {
+	Network string // "tcp" or "unix", defaults to "tcp"
+	Addr    string // address or socket path to listen on
+
+	// how long in-flight requests may take to finish after the server stops accepting
+	// connections, after which their contexts are canceled and their handlers are
+	// given as long again to return. Defaults to 30 seconds.
+	DrainTimeout time.Duration
+
+	// how long the server keeps accepting connections after readiness is turned off,
+	// giving load balancers time to stop routing requests to it
+	ReadinessDelay time.Duration
+
+	// how long each shutdown hook may take, defaults to 30 seconds
+	HookTimeout time.Duration
+
+	// signals that start a graceful shutdown, defaults to SIGTERM and SIGINT
+	Signals []os.Signal
+
+	// server used to serve requests, for its timeouts or TLS configuration.
+	// Its Handler and BaseContext are set if nil.
+	Server *http.Server
+}
+
+// OnShutdown registers a hook run after in-flight requests are drained. Hooks
+// are run in the order they are registered.
+func (om *OliveMartini) OnShutdown(hook ShutdownHook) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	om.shutdownHooks = append(om.shutdownHooks, hook)
+}
+
+// Shutdown starts a graceful shutdown of the running server, as if it received
+// a shutdown signal.
+func (om *OliveMartini) Shutdown() {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	select {
+	case <-om.stop:
+	default:
+		close(om.stop)
+	}
+}
+
+// ListenAndServe listens on the address and serves requests until the process receives
+// a shutdown signal or Shutdown is called. It then shuts down gracefully:
+//
+//  1. readiness is turned off and the server keeps serving for the ReadinessDelay
+//  2. the listener is closed and in-flight requests, including those on hijacked
+//     connections such as WebSockets, are given the DrainTimeout to finish
+//  3. the contexts of requests still in flight are canceled, their connections closed
+//     and their handlers given another DrainTimeout to return
+//  4. the shutdown hooks are run in order
+//
+// ListenAndServe returns nil after a graceful shutdown.
+func (om *OliveMartini) ListenAndServe(opts ServerOptions) error {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Network == "unix" {
+		// remove the socket left by a previous process
+		if fi, err := os.Stat(opts.Addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(opts.Addr)
+		}
+	}
+	l, err := net.Listen(opts.Network, opts.Addr)
+	if err != nil {
+		return err
+	}
+	return om.Serve(l, opts)
+}
+
+// Serve is like ListenAndServe but serves requests accepted by the listener, which it closes.
+func (om *OliveMartini) Serve(l net.Listener, opts ServerOptions) error {
+	if opts.DrainTimeout == 0 {
+		opts.DrainTimeout = 30 * time.Second
+	}
+	if opts.HookTimeout == 0 {
+		opts.HookTimeout = 30 * time.Second
+	}
+	if opts.Signals == nil {
+		opts.Signals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+	}
+	srv := opts.Server
+	if srv == nil {
+		srv = new(http.Server)
+	}
+	if srv.Handler == nil {
+		srv.Handler = om
+	}
+	// track handlers so that hooks run after canceled handlers return
+	var inflight sync.WaitGroup
+	handler := srv.Handler
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		inflight.Add(1)
+		defer inflight.Done()
+		handler.ServeHTTP(w, req)
+	})
+	// request contexts derive from the base context so they can be canceled after draining
+	base, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if srv.BaseContext == nil {
+		srv.BaseContext = func(net.Listener) context.Context { return base }
+	}
+
+	lg := log.New("addr", l.Addr().String())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, opts.Signals...)
+	defer signal.Stop(sigs)
+
+	served := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			served <- srv.ServeTLS(l, "", "")
+		} else {
+			served <- srv.Serve(l)
+		}
+	}()
+	lg.Info("serving")
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-sigs:
+		lg.Info("shutting down", "signal", sig)
+	case <-om.stop:
+		lg.Info("shutting down")
+	}
+
+	om.health.SetReady(false)
+	time.Sleep(opts.ReadinessDelay)
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), opts.DrainTimeout)
+	defer drainCancel()
+	// Shutdown doesn't wait for the handlers of hijacked connections
+	deadline, _ := drainCtx.Deadline()
+	drained := srv.Shutdown(drainCtx) == nil && waitTimeout(&inflight, time.Until(deadline))
+	if drained {
+		lg.Info("drained in-flight requests")
+	} else {
+		lg.Warn("requests still in flight after draining, canceling them", "timeout", opts.DrainTimeout)
+		srv.Close()
+	}
+	cancel()
+	if !waitTimeout(&inflight, opts.DrainTimeout) {
+		lg.Error("handlers didn't return after being canceled")
+	}
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		lg.Error("server failed", "err", err)
+	}
+
+	om.mu.Lock()
+	hooks := append([]ShutdownHook(nil), om.shutdownHooks...)
+	om.mu.Unlock()
+	var hookErr error
+	for i, hook := range hooks {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.HookTimeout)
+		err := hook(ctx)
+		cancel()
+		if err != nil {
+			lg.Error("shutdown hook failed", "hook", i, "err", err)
+			if hookErr == nil {
+				hookErr = err
+			}
+		}
+	}
+	lg.Info("shut down")
+	return hookErr
+}
+
+// waitTimeout waits for the group, returning false if the timeout elapses first
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
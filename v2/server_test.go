@@ -0,0 +1,56 @@
+package olive_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+)
+
+func TestShutdownWaitsForWebSockets(t *testing.T) {
+	var returned int32
+	connected := make(chan struct{})
+	o := olive.Martini()
+	o.Get("/ws", o.Endpoint(func(ws *olive.WebSocket) {
+		defer atomic.StoreInt32(&returned, 1)
+		close(connected)
+		var msg interface{}
+		ws.Receive(&msg)
+	}).WebSocket(&olive.WebSocketOptions{}))
+
+	var hookSawHandler int32 = -1
+	o.OnShutdown(func(ctx context.Context) error {
+		atomic.StoreInt32(&hookSawHandler, atomic.LoadInt32(&returned))
+		return nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- o.Serve(l, olive.ServerOptions{DrainTimeout: 100 * time.Millisecond}) }()
+
+	conn, br := dialWebSocket(t, l.Addr().String(), "/ws")
+	defer conn.Close()
+	<-connected
+
+	o.Shutdown()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't shut down")
+	}
+	if atomic.LoadInt32(&hookSawHandler) != 1 {
+		t.Error("shutdown hooks ran before the WebSocket handler returned")
+	}
+	if op, _ := readFrame(t, br); op != 0x8 {
+		t.Errorf("got frame with opcode %d, want a close frame", op)
+	}
+}
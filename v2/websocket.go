@@ -0,0 +1,506 @@
+package olive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-martini/martini"
+	log "github.com/inconshreveable/log15/v3"
+)
+
+// WebSocket close codes (RFC 6455 section 7.4.1)
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// WebSocket frame opcodes
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xa
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketOptions configures a WebSocket endpoint.
+type WebSocketOptions struct {
+	// origins allowed to open connections, as exact origins or path.Match patterns.
+	// If empty, only the request's own host is allowed.
+	Origins []string
+
+	MaxMessageSize int64         // largest inbound message in bytes, defaults to 1MB
+	PingInterval   time.Duration // how often the connection is pinged, defaults to 30 seconds
+}
+
+// A CloseError is returned by WebSocket.Receive when the connection is closed.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// A WebSocket is a connection upgraded by an Endpoint configured with WebSocket.
+// Messages are encoded with the codec of the negotiated subprotocol: the subtype
+// of one of the Endpoint's content types that it can both encode and decode, such
+// as "json" for application/json or "msgpack" for application/x-msgpack. If the
+// client doesn't request a subprotocol, the first encoder's codec is used.
+//
+//	o.Get("/chat", o.Endpoint(func(ws *olive.WebSocket) {
+//		for {
+//			var msg ChatMessage
+//			if err := ws.Receive(&msg); err != nil {
+//				return
+//			}
+//			ws.Send(room.Post(msg))
+//		}
+//	}).WebSocket(&olive.WebSocketOptions{}))
+//
+// The connection is closed when the handler returns. It may be written to from
+// multiple goroutines, but only one goroutine may Receive.
+type WebSocket struct {
+	log.Logger
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	enc         ContentEncoder
+	dec         Decoder
+	binary      bool
+	maxSize     int64
+	timeout     time.Duration
+	e           *errEncoder
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex // serializes writes
+	closed bool
+}
+
+// Subprotocol returns the negotiated subprotocol, or the empty string if the
+// client didn't request one.
+func (ws *WebSocket) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// Context returns a context that is done when the connection closes. The connection
+// is closed when the request's context is canceled, such as on server shutdown.
+func (ws *WebSocket) Context() context.Context {
+	return ws.ctx
+}
+
+// Receive decodes the next message into v. If the message can't be decoded, an
+// *Error is sent to the client and the decoding error is returned. If the
+// connection is closed, a *CloseError is returned.
+func (ws *WebSocket) Receive(v interface{}) error {
+	msg, err := ws.readMessage()
+	if err != nil {
+		return err
+	}
+	if err := ws.dec.Decode(bytes.NewReader(msg), v); err != nil {
+		ws.SendError(decodeFailure(err))
+		return err
+	}
+	return nil
+}
+
+// Send encodes v as a message.
+func (ws *WebSocket) Send(v interface{}) error {
+	var buf bytes.Buffer
+	if err := ws.enc.Encode(&buf, v); err != nil {
+		return err
+	}
+	op := opText
+	if ws.binary {
+		op = opBinary
+	}
+	return ws.writeFrame(op, buf.Bytes())
+}
+
+// SendError sends err as an *Error message, translating it the way Abort does.
+func (ws *WebSocket) SendError(err error) error {
+	apiErr, ok := translateError(err, ws.e.mappers)
+	if !ok {
+		apiErr = internalServerError(err)
+		if !ws.e.debug {
+			apiErr.Details = nil
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(apiErr.StatusCode)
+	}
+	ws.Warn(apiErr.Message, "status", apiErr.StatusCode)
+	return ws.Send(apiErr)
+}
+
+// Close sends a close frame with the code and reason and closes the connection.
+func (ws *WebSocket) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	err := ws.writeFrame(opClose, payload)
+	ws.shutdown()
+	return err
+}
+
+func (ws *WebSocket) shutdown() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if !ws.closed {
+		ws.closed = true
+		ws.cancel()
+		ws.conn.Close()
+	}
+}
+
+func (ws *WebSocket) writeFrame(op byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n <= 125:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.timeout))
+	if _, err := ws.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fail closes the connection because the client violated the protocol
+func (ws *WebSocket) fail(code int, reason string) error {
+	ws.Warn("closing websocket", "code", code, "reason", reason)
+	ws.Close(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// readMessage reads the next data message, answering control frames
+func (ws *WebSocket) readMessage() ([]byte, error) {
+	var (
+		msg     []byte
+		msgOp   byte
+		started bool
+	)
+	for {
+		// the client must answer pings within the ping interval
+		ws.conn.SetReadDeadline(time.Now().Add(2 * ws.timeout))
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			var ce *CloseError
+			if errors.As(err, &ce) {
+				return nil, ws.fail(ce.Code, ce.Reason)
+			}
+			ws.shutdown()
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			ws.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code, reason := CloseNoStatus, ""
+			if len(payload) >= 2 {
+				code, reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+			}
+			if code == CloseNoStatus {
+				ws.writeFrame(opClose, nil)
+				ws.shutdown()
+			} else {
+				ws.Close(code, "")
+			}
+			return nil, &CloseError{Code: code, Reason: reason}
+		case opText, opBinary:
+			if started {
+				return nil, ws.fail(CloseProtocolError, "expected continuation frame")
+			}
+			started, msgOp = true, op
+		case opContinuation:
+			if !started {
+				return nil, ws.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return nil, ws.fail(CloseProtocolError, "unknown opcode")
+		}
+		if int64(len(msg)+len(payload)) > ws.maxSize {
+			return nil, ws.fail(CloseMessageTooBig, "message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			if msgOp == opText && !utf8.Valid(msg) {
+				return nil, ws.fail(CloseInvalidPayload, "invalid UTF-8")
+			}
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks a frame, returning a *CloseError if it's invalid
+func (ws *WebSocket) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(ws.br, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	if hdr[0]&0x70 != 0 {
+		return fin, op, nil, &CloseError{CloseProtocolError, "reserved bits set"}
+	}
+	if hdr[1]&0x80 == 0 {
+		return fin, op, nil, &CloseError{CloseProtocolError, "client frames must be masked"}
+	}
+	n := int64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if op >= opClose && (n > 125 || !fin) {
+		return fin, op, nil, &CloseError{CloseProtocolError, "invalid control frame"}
+	}
+	if n < 0 || n > ws.maxSize {
+		return fin, op, nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(ws.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// ping pings the client until the connection closes
+func (ws *WebSocket) ping() {
+	t := time.NewTicker(ws.timeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := ws.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		case <-ws.ctx.Done():
+			// the request was canceled, e.g. by a server shutdown
+			ws.Close(CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+// subprotocolName returns the WebSocket subprotocol of a content type
+func subprotocolName(contentType string) string {
+	_, sub := split(contentType, "/")
+	return strings.TrimPrefix(sub, "x-")
+}
+
+// negotiateSubprotocol picks the first subprotocol requested by the client that
+// the codecs support. If the client requests none, the first encoder is used.
+func negotiateSubprotocol(requested string, encs []ContentEncoder, decs map[string]Decoder) (string, ContentEncoder, Decoder, bool) {
+	if requested == "" {
+		for _, enc := range encs {
+			if dec, ok := decs[enc.ContentType]; ok {
+				return "", enc, dec, true
+			}
+		}
+		return "", ContentEncoder{}, nil, false
+	}
+	for _, proto := range strings.Split(requested, ",") {
+		proto = strings.TrimSpace(proto)
+		for _, enc := range encs {
+			if subprotocolName(enc.ContentType) != proto {
+				continue
+			}
+			if dec, ok := decs[enc.ContentType]; ok {
+				return proto, enc, dec, true
+			}
+		}
+	}
+	return "", ContentEncoder{}, nil, false
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// originAllowed checks the Origin of a request to prevent cross-site WebSocket hijacking
+func originAllowed(req *http.Request, origins []string) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(origins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, req.Host)
+	}
+	for _, o := range origins {
+		if ok, _ := path.Match(o, origin); ok || o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// websocketMiddleware upgrades requests to WebSocket connections and injects a
+// *WebSocket into the rest of the chain.
+func websocketMiddleware(opts *WebSocketOptions, encs []ContentEncoder, decs map[string]Decoder) martini.Handler {
+	return func(c martini.Context, w http.ResponseWriter, req *http.Request, e *errEncoder, l log.Logger) {
+		if !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket") {
+			w.Header().Set("Upgrade", "websocket")
+			e.Abort(&Error{
+				StatusCode: http.StatusUpgradeRequired,
+				Message:    "websocket upgrade required",
+			})
+		}
+		key := req.Header.Get("Sec-WebSocket-Key")
+		if req.Method != http.MethodGet || key == "" || req.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			e.Abort(&Error{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid websocket handshake",
+			})
+		}
+		if !originAllowed(req, opts.Origins) {
+			e.Abort(&Error{
+				StatusCode: http.StatusForbidden,
+				Message:    "websocket origin not allowed",
+				Details:    M{"origin": req.Header.Get("Origin")},
+			})
+		}
+		proto, enc, dec, ok := negotiateSubprotocol(req.Header.Get("Sec-WebSocket-Protocol"), encs, decs)
+		if !ok {
+			supported := make([]string, 0, len(encs))
+			for _, enc := range encs {
+				if _, ok := decs[enc.ContentType]; ok {
+					supported = append(supported, subprotocolName(enc.ContentType))
+				}
+			}
+			e.Abort(&Error{
+				StatusCode: http.StatusBadRequest,
+				Message:    "unsupported websocket subprotocol",
+				Details:    M{"requested": req.Header.Get("Sec-WebSocket-Protocol"), "supported": supported},
+			})
+		}
+
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			e.Abort(errors.New("response writer doesn't support hijacking"))
+		}
+		conn, brw, err := hj.Hijack()
+		if err != nil {
+			e.Abort(err)
+		}
+		sum := sha1.Sum([]byte(key + websocketGUID))
+		handshake := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+		if proto != "" {
+			handshake += "Sec-WebSocket-Protocol: " + proto + "\r\n"
+		}
+		if _, err := conn.Write([]byte(handshake + "\r\n")); err != nil {
+			l.Warn("websocket handshake failed", "err", err)
+			conn.Close()
+			return
+		}
+
+		ws := &WebSocket{
+			Logger:      l.New("subprotocol", proto),
+			conn:        conn,
+			br:          brw.Reader,
+			subprotocol: proto,
+			enc:         enc,
+			dec:         dec,
+			binary:      !strings.Contains(enc.ContentType, "json") && !strings.Contains(enc.ContentType, "xml") && !strings.HasPrefix(enc.ContentType, "text/"),
+			maxSize:     opts.MaxMessageSize,
+			timeout:     opts.PingInterval,
+			e:           e,
+		}
+		if ws.maxSize == 0 {
+			ws.maxSize = 1 << 20
+		}
+		if ws.timeout == 0 {
+			ws.timeout = 30 * time.Second
+		}
+		ws.ctx, ws.cancel = context.WithCancel(req.Context())
+		ws.Info("websocket connected")
+		go ws.ping()
+
+		// the connection is no longer HTTP, aborts send their error as a message
+		e.ws = ws
+		defer func() {
+			if p := recover(); p != nil {
+				if _, ok := p.(abort); !ok {
+					ws.SendError(&Error{StatusCode: http.StatusInternalServerError})
+				}
+				ws.Close(CloseInternalError, "")
+				panic(p)
+			}
+			ws.Close(CloseNormal, "")
+			ws.Info("websocket closed")
+		}()
+		c.Map(ws)
+		c.Next()
+	}
+}
@@ -0,0 +1,100 @@
+package olive_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/olive/v2"
+)
+
+// dialWebSocket opens a WebSocket connection to the path on the server at addr
+func dialWebSocket(t *testing.T, addr, path string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake failed with %d", resp.StatusCode)
+	}
+	return conn, br
+}
+
+// readFrame reads an unmasked frame sent by the server
+func readFrame(t *testing.T, br *bufio.Reader) (op byte, payload []byte) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return hdr[0] & 0x0f, payload
+}
+
+func TestWebSocketAbortSendsErrorMessage(t *testing.T) {
+	o := olive.Martini()
+	o.Get("/ws", o.Endpoint(func(ws *olive.WebSocket, r olive.Response) {
+		r.Abort(&olive.Error{StatusCode: http.StatusConflict, Message: "room is full"})
+	}).WebSocket(&olive.WebSocketOptions{}))
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+
+	conn, br := dialWebSocket(t, srv.Listener.Addr().String(), "/ws")
+	defer conn.Close()
+
+	op, payload := readFrame(t, br)
+	if op != 0x1 {
+		t.Fatalf("got frame with opcode %d, want a text message", op)
+	}
+	var apiErr olive.Error
+	if err := json.Unmarshal(payload, &apiErr); err != nil {
+		t.Fatalf("error message %q: %v", payload, err)
+	}
+	if apiErr.StatusCode != http.StatusConflict || apiErr.Message != "room is full" {
+		t.Errorf("got error %+v", apiErr)
+	}
+	if op, payload = readFrame(t, br); op != 0x8 {
+		t.Fatalf("got frame with opcode %d, want a close frame", op)
+	}
+	if code := binary.BigEndian.Uint16(payload); code != olive.CloseInternalError {
+		t.Errorf("closed with code %d, want %d", code, olive.CloseInternalError)
+	}
+
+	// nothing but WebSocket frames were written to the connection
+	if n, err := br.Read(make([]byte, 1)); err == nil {
+		t.Errorf("read %d more bytes after the close frame", n)
+	}
+}